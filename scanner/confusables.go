@@ -0,0 +1,59 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import "unicode"
+
+// confusables maps Cyrillic and Greek letters that are commonly
+// mistaken for Latin ones to the ASCII letter they resemble, their
+// skeleton. The table is a subset of the Unicode confusables data
+// (UTS #39) restricted to letters that look like ASCII letters.
+//
+var confusables = map[rune]rune{
+	// Cyrillic
+	'Ѕ': 'S', 'І': 'I', 'Ј': 'J', 'А': 'A', 'В': 'B',
+	'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O',
+	'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X', 'Ү': 'Y',
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c',
+	'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ј': 'j',
+	'һ': 'h', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+
+	// Greek
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H',
+	'Ι': 'I', 'Κ': 'K', 'Μ': 'M', 'Ν': 'N', 'Ο': 'O',
+	'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X', 'α': 'a',
+	'ι': 'i', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+}
+
+// CheckConfusables returns the characters of lit, in order of their
+// first occurrence, that resemble an ASCII letter but belong to another
+// script, provided lit also contains a Latin letter. Such mixed-script
+// literals, like pаth with a Cyrillic а, look like an all-Latin spelling
+// but are different identifiers or string keys. CheckConfusables returns
+// nil for literals written in a single script, such as ASCII or all
+// Cyrillic identifiers. It is intended for linters checking identifier
+// and string literals; see also the WarnConfusables mode.
+//
+func CheckConfusables(lit string) []rune {
+	latin := false
+	var list []rune
+	for _, ch := range lit {
+		if _, ok := confusables[ch]; ok {
+			dup := false
+			for _, r := range list {
+				dup = dup || r == ch
+			}
+			if !dup {
+				list = append(list, ch)
+			}
+		} else if unicode.Is(unicode.Latin, ch) {
+			latin = true
+		}
+	}
+	if !latin {
+		return nil
+	}
+	return list
+}
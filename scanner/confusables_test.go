@@ -0,0 +1,72 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vastri/zolang/token"
+)
+
+func TestCheckConfusables(t *testing.T) {
+	for _, test := range []struct {
+		lit  string
+		want string
+	}{
+		// single script
+		{"path", "[]"},
+		{"_x1", "[]"},
+		{"café", "[]"},
+		{"путь", "[]"}, // all Cyrillic
+		{"ορος", "[]"}, // all Greek
+		{"世界", "[]"},   // no confusable letters
+		{"xбдж", "[]"}, // Cyrillic letters that do not look Latin
+		{"Λλx", "[]"},  // Greek letters that do not look Latin
+
+		// mixed script
+		{"pаth", "[U+0430]"},                // Cyrillic а
+		{"Сounter", "[U+0421]"},             // Cyrillic С
+		{"tооld", "[U+043E]"},               // repeated Cyrillic о
+		{"раy", "[U+0440 U+0430]"},          // Cyrillic р and а
+		{"Οbject", "[U+039F]"},              // Greek Ο
+		{"caféс", "[U+0441]"},               // Latin-1 letter and Cyrillic с
+		{"userіd νalue", "[U+0456 U+03BD]"}, // string key
+	} {
+		if got := fmt.Sprintf("%U", CheckConfusables(test.lit)); got != test.want {
+			t.Errorf("%q: got %s; expected %s", test.lit, got, test.want)
+		}
+	}
+}
+
+func TestWarnConfusables(t *testing.T) {
+	const src = "path pаth раy путь"
+	for _, mode := range []Mode{0, WarnConfusables} {
+		fset := token.NewFileSet()
+		var warns []string
+		var s Scanner
+		s.Warn = func(pos token.Position, msg string) {
+			warns = append(warns, fmt.Sprintf("%d: %s", pos.Offset, msg))
+		}
+		s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, mode)
+		for {
+			_, tok, lit := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			if tok != token.IDENT {
+				t.Errorf("mode %d: got %s %q; expected identifiers only", mode, tok, lit)
+			}
+		}
+		want := "[]"
+		if mode != 0 {
+			want = "[6: identifier contains mixed-script confusable character U+0430 (looks like 'a') " +
+				"11: identifier contains mixed-script confusable character U+0440 (looks like 'p')]"
+		}
+		if got := fmt.Sprint(warns); got != want || s.ErrorCount != 0 {
+			t.Errorf("mode %d: got warnings %s and %d errors; expected %s", mode, got, s.ErrorCount, want)
+		}
+	}
+}
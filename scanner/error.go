@@ -5,9 +5,12 @@
 package scanner
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/vastri/zolang/token"
 )
@@ -41,6 +44,43 @@ func (p *ErrorList) Add(pos token.Position, msg string) { *p = append(*p, &Error
 // Reset resets an ErrorList to no errors.
 func (p *ErrorList) Reset() { *p = (*p)[0:0] }
 
+// A LimitedErrorList is an ErrorList that stores at most a limited number
+// of errors, the first ones added, and records whether more were added.
+// It caps the memory used for collecting the errors of large or hostile
+// sources. The zero value for a LimitedErrorList has no limit; it is
+// an empty list ready to use.
+//
+type LimitedErrorList struct {
+	ErrorList // errors stored
+	limit     int
+	truncated bool
+}
+
+// SetLimit sets the maximum number of errors stored by l to n; a limit
+// of 0 or less means no limit. Errors stored already are kept.
+//
+func (l *LimitedErrorList) SetLimit(n int) { l.limit = n }
+
+// Add adds an Error with given position and error message to l, unless
+// l has reached its limit.
+//
+func (l *LimitedErrorList) Add(pos token.Position, msg string) {
+	if l.limit > 0 && len(l.ErrorList) >= l.limit {
+		l.truncated = true
+		return
+	}
+	l.ErrorList.Add(pos, msg)
+}
+
+// Truncated reports whether errors were dropped by Add because l was full.
+func (l *LimitedErrorList) Truncated() bool { return l.truncated }
+
+// Reset resets l to no errors, keeping its limit.
+func (l *LimitedErrorList) Reset() {
+	l.ErrorList.Reset()
+	l.truncated = false
+}
+
 // ErrorList implements the sort interface.
 func (l ErrorList) Len() int      { return len(l) }
 func (l ErrorList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
@@ -89,6 +129,17 @@ func (l ErrorList) Error() string {
 	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
 }
 
+// Unwrap returns the errors of the list, so that errors.Is and errors.As
+// examine each *Error in turn.
+//
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, e := range l {
+		errs[i] = e
+	}
+	return errs
+}
+
 // Err returns an error equivalent to this error list.
 // If the list is empty, Error returns nil.
 //
@@ -99,6 +150,47 @@ func (l ErrorList) Err() error {
 	return l
 }
 
+// Format returns the errors of the list formatted according to format,
+// one error per line. In format, the placeholders {file}, {line}, {col},
+// and {msg} are replaced by the error's filename, line, column, and
+// message, respectively. For instance, "{file}:{line}:{col}: {msg}" is
+// the format used by Error for errors with a valid position.
+//
+func (l ErrorList) Format(format string) string {
+	var buf strings.Builder
+	for _, e := range l {
+		r := strings.NewReplacer(
+			"{file}", e.Pos.Filename,
+			"{line}", strconv.Itoa(e.Pos.Line),
+			"{col}", strconv.Itoa(e.Pos.Column),
+			"{msg}", e.Msg,
+		)
+		r.WriteString(&buf, format)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// formatJSONError is the JSON representation of an Error used by FormatJSON.
+type formatJSONError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Message string `json:"message"`
+}
+
+// FormatJSON returns the errors of the list as a JSON array with one
+// object per error, in list order. Each object has the fields file,
+// line, col, and message.
+//
+func (l ErrorList) FormatJSON() ([]byte, error) {
+	out := make([]formatJSONError, len(l))
+	for i, e := range l {
+		out[i] = formatJSONError{e.Pos.Filename, e.Pos.Line, e.Pos.Column, e.Msg}
+	}
+	return json.Marshal(out)
+}
+
 // PrintError is a utility function that prints a list of errors to w,
 // one error per line, if the err parameter is an ErrorList. Otherwise
 // it prints the err string.
@@ -112,3 +204,24 @@ func PrintError(w io.Writer, err error) {
 		fmt.Fprintf(w, "%s\n", err)
 	}
 }
+
+// jsonError is the JSON representation of an Error used by PrintErrorJSON.
+type jsonError struct {
+	Filename string `json:"filename"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Offset   int    `json:"offset"`
+	Message  string `json:"message"`
+}
+
+// PrintErrorJSON is a utility function that prints a list of errors to w
+// as a JSON array with one object per error, in list order. Each object
+// has the fields filename, line, column, offset, and message.
+//
+func PrintErrorJSON(w io.Writer, list ErrorList) error {
+	out := make([]jsonError, len(list))
+	for i, e := range list {
+		out[i] = jsonError{e.Pos.Filename, e.Pos.Line, e.Pos.Column, e.Pos.Offset, e.Msg}
+	}
+	return json.NewEncoder(w).Encode(out)
+}
@@ -5,7 +5,12 @@
 package scanner
 
 import (
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/vastri/zolang/token"
@@ -50,6 +55,469 @@ func testError(t *testing.T) {
 	}
 }
 
+func TestPrintErrorJSON(t *testing.T) {
+	const src = "@\n@ @\n"
+
+	fset := token.NewFileSet()
+
+	var list ErrorList
+	eh := func(pos token.Position, msg string) { list.Add(pos, msg) }
+
+	var s Scanner
+	s.Init(fset.AddFile("File1", fset.Base(), len(src)), []byte(src), eh)
+	for {
+		if _, tok, _ := s.Scan(); tok == token.EOF {
+			break
+		}
+	}
+	list.Add(token.Position{Filename: "File0"}, "no position")
+	list.Sort()
+
+	var buf bytes.Buffer
+	if err := PrintErrorJSON(&buf, list); err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		Filename string
+		Line     int
+		Column   int
+		Offset   int
+		Message  string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %s", buf.String(), err)
+	}
+	if len(got) != len(list) {
+		t.Fatalf("got %d errors, expected %d", len(got), len(list))
+	}
+	for i, e := range list {
+		g := got[i]
+		if g.Filename != e.Pos.Filename || g.Line != e.Pos.Line || g.Column != e.Pos.Column || g.Offset != e.Pos.Offset || g.Message != e.Msg {
+			t.Errorf("error %d: got %+v, expected %s", i, g, e)
+		}
+	}
+
+	buf.Reset()
+	if err := PrintErrorJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[]\n" {
+		t.Errorf("empty list: got %q, expected %q", got, "[]\n")
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	const src = "@ @ @ @ @ @ @ @ @ @ end"
+
+	fset := token.NewFileSet()
+
+	var list ErrorList
+	eh := func(pos token.Position, msg string) { list.Add(pos, msg) }
+
+	var s Scanner
+	s.MaxErrors = 3
+	s.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), eh)
+
+	n := 0
+	var pos token.Pos
+	for {
+		var tok token.Token
+		if pos, tok, _ = s.Scan(); tok == token.EOF {
+			break
+		}
+		n++
+	}
+
+	if len(list) != 4 || s.ErrorCount != 3 {
+		t.Errorf("got %d errors (ErrorCount = %d), expected 4 (3)", len(list), s.ErrorCount)
+		PrintError(os.Stderr, list)
+	} else if list[3].Msg != "too many errors" || list[3].Pos != list[2].Pos {
+		t.Errorf("got final error %s, expected %s: too many errors", list[3], list[2].Pos)
+	}
+	if n != 3 {
+		t.Errorf("got %d tokens before EOF, expected 3", n)
+	}
+	if offs := fset.Position(pos).Offset; offs >= len(src) {
+		t.Errorf("got EOF at offset %d, expected it before the end of source", offs)
+	}
+	if _, tok, _ := s.Scan(); tok != token.EOF {
+		t.Errorf("got %s after EOF, expected EOF", tok)
+	}
+}
+
+func TestMaxErrorsGarbage(t *testing.T) {
+	src := bytes.Repeat([]byte("\x00@\xff~$"), 10000)
+	fset := token.NewFileSet()
+	for _, max := range []int{0, 1, 10} {
+		calls := 0
+		var s Scanner
+		s.MaxErrors = max
+		s.Init(fset.AddFile("", fset.Base(), len(src)), src, func(token.Position, string) { calls++ })
+		for {
+			if _, tok, _ := s.Scan(); tok == token.EOF {
+				break
+			}
+		}
+		switch {
+		case max == 0 && calls < 10000:
+			t.Errorf("no limit: got only %d handler calls", calls)
+		case max > 0 && calls != max+1:
+			t.Errorf("MaxErrors %d: got %d handler calls, expected %d", max, calls, max+1)
+		}
+		for i := 0; i < 3; i++ {
+			if _, tok, _ := s.Scan(); tok != token.EOF {
+				t.Errorf("MaxErrors %d: got %s after EOF, expected EOF", max, tok)
+			}
+		}
+	}
+}
+
+func TestMaxLiteralLen(t *testing.T) {
+	const max = 1 << 10
+	huge := strings.Repeat("a", 4<<20)
+	for _, test := range []struct {
+		src  string
+		mode Mode
+		tok  token.Token
+		lit  string
+		errs int // number of errors including "token too long"
+	}{
+		{`"` + huge, 0, token.STRING, `"` + huge[:max-1], 2}, // not terminated
+		{`"` + huge + `"`, 0, token.STRING, `"` + huge[:max-1], 1},
+		{`'` + huge, 0, token.RAWSTRING, `'` + huge[:max-1], 2},
+		{`r"` + huge + `"`, 0, token.RAWSTRING, `r"` + huge[:max-2], 1},
+		{huge, 0, token.IDENT, huge[:max], 1},
+		{"//" + huge, RetainCommentText, token.COMMENT, "//" + huge[:max-2], 1},
+		{"/*" + huge + "\n" + huge + "*/", RetainCommentText, token.COMMENT, "/*" + huge[:max-2], 1},
+		{"x" + strings.Repeat("世", max), 0, token.IDENT, "x" + strings.Repeat("世", (max-1)/3), 1}, // truncated at a character boundary
+	} {
+		// The long token is followed by the remaining source on the next line.
+		src := "x " + test.src + "\ny"
+		fset := token.NewFileSet()
+		var list ErrorList
+		var s Scanner
+		s.MaxLiteralLen = max
+		s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), func(pos token.Position, msg string) { list.Add(pos, msg) }, test.mode)
+		var toks []token.Token
+		var lits []string
+		for {
+			_, tok, lit := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			toks = append(toks, tok)
+			lits = append(lits, lit)
+		}
+		name := fmt.Sprintf("%.10q...", test.src)
+		if fmt.Sprint(toks) != fmt.Sprintf("[IDENT %s IDENT]", test.tok) {
+			t.Errorf("%s: got tokens %v; expected IDENT %s IDENT", name, toks, test.tok)
+			continue
+		}
+		if lits[1] != test.lit || lits[2] != "y" {
+			t.Errorf("%s: got literals %.10q (len %d) and %q", name, lits[1], len(lits[1]), lits[2])
+		}
+		long := 0
+		for _, e := range list {
+			if e.Msg == "token too long" && e.Pos.Offset == 2 {
+				long++
+			}
+		}
+		if len(list) != test.errs || long != 1 {
+			t.Errorf("%s: got errors %.100s; expected %d including token too long at offset 2", name, list, test.errs)
+		}
+	}
+
+	// The tokens following a long string on the same line are scanned.
+	for _, quote := range []string{`"`, `'`, `r"`} {
+		src := quote + "abcdefghijk" + quote[len(quote)-1:] + " bb 12 // c"
+		fset := token.NewFileSet()
+		var s Scanner
+		s.MaxLiteralLen = 4
+		s.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil)
+		var got []string
+		for {
+			_, tok, lit := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			got = append(got, fmt.Sprintf("%s %s", tok, lit))
+		}
+		// Only the string exceeds the limit.
+		want := fmt.Sprintf("[%s %s IDENT bb INT 12 COMMENT ]", map[string]string{`"`: "STRING", `'`: "RAWSTRING", `r"`: "RAWSTRING"}[quote], src[:4])
+		if fmt.Sprint(got) != want || s.ErrorCount != 1 {
+			t.Errorf("%s: got %v with %d errors; expected %s with 1", src, got, s.ErrorCount, want)
+		}
+	}
+
+	// Tokens within the limit are not affected.
+	const src = "abc \"ab\" //a"
+	fset := token.NewFileSet()
+	var s Scanner
+	s.MaxLiteralLen = 4
+	s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, RetainCommentText)
+	var lits []string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		lits = append(lits, lit)
+	}
+	if got := fmt.Sprint(lits); got != `[abc "ab" //a]` || s.ErrorCount != 0 {
+		t.Errorf("got literals %s with %d errors; expected them unchanged with none", got, s.ErrorCount)
+	}
+}
+
+func TestInvalidUTF8Run(t *testing.T) {
+	tests := []struct {
+		src  string
+		toks string // tokens and literals
+		errs string // positions and messages of the errors
+	}{
+		{strings.Repeat("\x80", 100), "[ILLEGAL:" + strings.Repeat("\x80", 100) + "]", "[1:1: illegal UTF-8 encoding]"},
+		{"a\xff\xfe b", "[IDENT:a ILLEGAL:\xff\xfe IDENT:b]", "[1:2: illegal UTF-8 encoding]"},
+		{"\x80 \x80", "[ILLEGAL:\x80 ILLEGAL:\x80]", "[1:1: illegal UTF-8 encoding 1:3: illegal UTF-8 encoding]"},
+		{"\x80\ufffd", "[ILLEGAL:\x80 ILLEGAL:\ufffd]", "[1:1: illegal UTF-8 encoding 1:2: illegal character U+FFFD '\ufffd']"},
+		{"\"\x80\x80\" '\xff\xff'", "[STRING:\"\x80\x80\" RAWSTRING:'\xff\xff']", "[1:2: illegal UTF-8 encoding 1:7: illegal UTF-8 encoding]"},
+		{"// \x80\x80\n\x80", "[COMMENT: ILLEGAL:\x80]", "[1:4: illegal UTF-8 encoding 2:1: illegal UTF-8 encoding]"},
+	}
+	for _, test := range tests {
+		fset := token.NewFileSet()
+		var list ErrorList
+		var s Scanner
+		s.Init(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), func(pos token.Position, msg string) { list.Add(pos, msg) })
+		var toks, errs []string
+		for {
+			_, tok, lit := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			toks = append(toks, tok.String()+":"+lit)
+		}
+		for _, e := range list {
+			errs = append(errs, e.Error())
+		}
+		if got := fmt.Sprint(toks); got != test.toks {
+			t.Errorf("%q: got tokens %q; expected %q", test.src, got, test.toks)
+		}
+		if got := fmt.Sprint(errs); got != test.errs || s.ErrorCount != len(list) {
+			t.Errorf("%q: got errors %s (count %d); expected %s", test.src, got, s.ErrorCount, test.errs)
+		}
+	}
+}
+
+func TestInitWithContext(t *testing.T) {
+	const src = "a @\r\n\tb := \"c\n\n\n~ d\rx $"
+	tests := []struct {
+		mode Mode
+		want []string
+	}{
+		{0, []string{
+			`1:3: illegal character U+0040 '@' in "a @"`,
+			`2:7: string literal not terminated in "\tb := \"c"`,
+			`5:1: illegal character U+007E '~' in "~ d\rx $"`,
+			`5:7: illegal character U+0024 '$' in "~ d\rx $"`,
+		}},
+		{CRLineEndings, []string{
+			`1:3: illegal character U+0040 '@' in "a @"`,
+			`2:7: string literal not terminated in "\tb := \"c"`,
+			`5:1: illegal character U+007E '~' in "~ d"`,
+			`6:3: illegal character U+0024 '$' in "x $"`,
+		}},
+	}
+	for _, test := range tests {
+		fset := token.NewFileSet()
+		var got []string
+		var s Scanner
+		s.InitWithContext(fset.AddFile("", fset.Base(), len(src)), []byte(src), func(pos token.Position, msg, lineText string) {
+			got = append(got, fmt.Sprintf("%s: %s in %q", pos, msg, lineText))
+		}, test.mode)
+		for {
+			if _, tok, _ := s.Scan(); tok == token.EOF {
+				break
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("mode %d: got errors\n%s\nexpected\n%s", test.mode, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+
+	// A nil handler is permitted.
+	fset := token.NewFileSet()
+	var s Scanner
+	s.InitWithContext(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, 0)
+	for {
+		if _, tok, _ := s.Scan(); tok == token.EOF {
+			break
+		}
+	}
+	if s.ErrorCount != 4 {
+		t.Errorf("got %d errors; expected 4", s.ErrorCount)
+	}
+}
+
+func TestErrorListUnwrap(t *testing.T) {
+	var list ErrorList
+	list.Add(token.Position{Filename: "a", Offset: 1, Line: 1, Column: 2}, "first")
+	list.Add(token.Position{Filename: "b", Offset: 4, Line: 2, Column: 1}, "second")
+	err := list.Err()
+
+	var e *Error
+	if !stderrors.As(err, &e) || e != list[0] {
+		t.Errorf("errors.As: got %v; expected %v", e, list[0])
+	}
+	if !stderrors.Is(err, list[1]) {
+		t.Errorf("errors.Is: %v not found", list[1])
+	}
+	if stderrors.Is(err, &Error{list[1].Pos, list[1].Msg}) {
+		t.Errorf("errors.Is: found an error not in the list")
+	}
+	if stderrors.As(ErrorList(nil), &e) {
+		t.Errorf("errors.As: found an error in an empty list")
+	}
+}
+
+func TestLimitedErrorList(t *testing.T) {
+	var list LimitedErrorList
+	list.SetLimit(10)
+	for i := 0; i < 100; i++ {
+		list.Add(token.Position{Offset: i, Line: i + 1, Column: 1}, fmt.Sprintf("error %d", i))
+	}
+	if list.Len() != 10 || !list.Truncated() {
+		t.Fatalf("got %d errors, truncated %v; expected 10, true", list.Len(), list.Truncated())
+	}
+	for i, e := range list.ErrorList {
+		if e.Pos.Offset != i || e.Msg != fmt.Sprintf("error %d", i) {
+			t.Errorf("error %d: got %s; expected the first errors", i, e)
+		}
+	}
+	if err, ok := list.Err().(ErrorList); !ok || len(err) != 10 {
+		t.Errorf("got Err() = %v", list.Err())
+	}
+
+	list.Reset()
+	list.Add(token.Position{}, "x")
+	if list.Len() != 1 || list.Truncated() {
+		t.Errorf("after Reset, got %d errors, truncated %v; expected 1, false", list.Len(), list.Truncated())
+	}
+
+	// The zero value has no limit.
+	var unlimited LimitedErrorList
+	for i := 0; i < 100; i++ {
+		unlimited.Add(token.Position{}, "x")
+	}
+	if unlimited.Len() != 100 || unlimited.Truncated() {
+		t.Errorf("without limit, got %d errors, truncated %v; expected 100, false", unlimited.Len(), unlimited.Truncated())
+	}
+}
+
+func TestErrorListFormat(t *testing.T) {
+	var list ErrorList
+	list.Add(token.Position{Filename: "a.zo", Offset: 3, Line: 1, Column: 4}, "illegal character U+0040 '@'")
+	list.Add(token.Position{Filename: "b.zo", Offset: 10, Line: 2, Column: 5}, "string literal not terminated")
+
+	for _, test := range []struct {
+		format, want string
+	}{
+		{"{file}:{line}:{col}: {msg}", "a.zo:1:4: illegal character U+0040 '@'\nb.zo:2:5: string literal not terminated\n"},
+		{"{file}:{line}:{col}: error: {msg}", "a.zo:1:4: error: illegal character U+0040 '@'\nb.zo:2:5: error: string literal not terminated\n"},
+		{"{file}\t{line}\t{col}\t{msg}", "a.zo\t1\t4\tillegal character U+0040 '@'\nb.zo\t2\t5\tstring literal not terminated\n"},
+		{"{msg} ({line}, {line})", "illegal character U+0040 '@' (1, 1)\nstring literal not terminated (2, 2)\n"},
+		{"{unknown}", "{unknown}\n{unknown}\n"},
+	} {
+		if got := list.Format(test.format); got != test.want {
+			t.Errorf("Format(%q) = %q, expected %q", test.format, got, test.want)
+		}
+	}
+	for i, e := range list {
+		if got, want := list[i:i+1].Format("{file}:{line}:{col}: {msg}"), e.Error()+"\n"; got != want {
+			t.Errorf("Format of default = %q, expected %q", got, want)
+		}
+	}
+	if got := ErrorList(nil).Format("{msg}"); got != "" {
+		t.Errorf("empty list: got %q, expected empty string", got)
+	}
+
+	b, err := list.FormatJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("invalid JSON %s: %s", b, err)
+	}
+	if len(got) != len(list) {
+		t.Fatalf("got %d errors, expected %d", len(got), len(list))
+	}
+	for i, e := range list {
+		want := map[string]interface{}{
+			"file":    e.Pos.Filename,
+			"line":    float64(e.Pos.Line),
+			"col":     float64(e.Pos.Column),
+			"message": e.Msg,
+		}
+		if fmt.Sprint(got[i]) != fmt.Sprint(want) {
+			t.Errorf("error %d: got %v, expected %v", i, got[i], want)
+		}
+	}
+	if b, _ := ErrorList(nil).FormatJSON(); string(b) != "[]" {
+		t.Errorf("empty list: got %s, expected []", b)
+	}
+}
+
+func TestScanErr(t *testing.T) {
+	fset := token.NewFileSet()
+	for _, e := range errors {
+		for _, peek := range []bool{false, true} {
+			var s Scanner
+			calls := 0
+			s.Init(fset.AddFile("", fset.Base(), len(e.src)), []byte(e.src), func(token.Position, string) { calls++ })
+			if peek {
+				s.PeekToken()
+			}
+			_, tok, _, err := s.ScanErr()
+			if tok != e.tok {
+				t.Errorf("%q: got %s, expected %s", e.src, tok, e.tok)
+			}
+			switch err := err.(type) {
+			case nil:
+				if e.err != "" {
+					t.Errorf("%q: got no error, expected %q", e.src, e.err)
+				}
+			case *Error:
+				if err.Msg != e.err || err.Pos.Offset != e.pos {
+					t.Errorf("%q: got error %q at %d, expected %q at %d", e.src, err.Msg, err.Pos.Offset, e.err, e.pos)
+				}
+			default:
+				t.Errorf("%q: got error %v, expected %q", e.src, err, e.err)
+			}
+			if calls != s.ErrorCount {
+				t.Errorf("%q: got %d handler calls and ErrorCount %d", e.src, calls, s.ErrorCount)
+			}
+		}
+	}
+
+	// Errors of Init and of the token itself belong together; the
+	// following tokens have their own.
+	const src = "\x00 \"\\q\\z\" x &"
+	var s Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil)
+	want := []int{2, 2, 0, 1, 0}
+	for i, n := range want {
+		_, tok, _, err := s.ScanErr()
+		got := 0
+		switch err := err.(type) {
+		case *Error:
+			got = 1
+		case ErrorList:
+			got = len(err)
+		}
+		if got != n {
+			t.Errorf("token %d (%s): got %d errors (%v), expected %d", i, tok, got, err, n)
+		}
+	}
+}
+
 type errorCollector struct {
 	cnt int            // number of errors encountered
 	msg string         // last error message encountered
@@ -97,7 +565,12 @@ var errors = []struct {
 	{"\a", token.ILLEGAL, 0, "", "illegal character U+0007"},
 	{`#`, token.ILLEGAL, 0, "", "illegal character U+0023 '#'"},
 	{`…`, token.ILLEGAL, 0, "", "illegal character U+2026 '…'"},
-	{`' '`, token.RAWSTRING, 0, `' '`, ""},
+	{`' '`, token.CHAR, 0, `' '`, ""},
+	{`'a'`, token.CHAR, 0, `'a'`, ""},
+	{`'\n'`, token.CHAR, 0, `'\n'`, ""},
+	{`'\u0041'`, token.CHAR, 0, `'\u0041'`, ""},
+	{`'ab'`, token.RAWSTRING, 0, `'ab'`, ""},
+	{`'\q'`, token.RAWSTRING, 2, `'\q'`, "unknown escape sequence"},
 	{`''`, token.RAWSTRING, 0, `''`, ""},
 	{`'12'`, token.RAWSTRING, 0, `'12'`, ""},
 	{`'123'`, token.RAWSTRING, 0, `'123'`, ""},
@@ -112,7 +585,7 @@ var errors = []struct {
 	{`'\u0'`, token.RAWSTRING, 4, `'\u0'`, "illegal character U+0027 ''' in escape sequence"},
 	{`'\u00'`, token.RAWSTRING, 5, `'\u00'`, "illegal character U+0027 ''' in escape sequence"},
 	{`'\u000'`, token.RAWSTRING, 6, `'\u000'`, "illegal character U+0027 ''' in escape sequence"},
-	{`'\u0000'`, token.RAWSTRING, 0, `'\u0000'`, ""},
+	{`'\u0000'`, token.CHAR, 0, `'\u0000'`, ""},
 	{`'\U'`, token.RAWSTRING, 3, `'\U'`, "illegal character U+0027 ''' in escape sequence"},
 	{`'\U0'`, token.RAWSTRING, 4, `'\U0'`, "illegal character U+0027 ''' in escape sequence"},
 	{`'\U00'`, token.RAWSTRING, 5, `'\U00'`, "illegal character U+0027 ''' in escape sequence"},
@@ -121,13 +594,25 @@ var errors = []struct {
 	{`'\U00000'`, token.RAWSTRING, 8, `'\U00000'`, "illegal character U+0027 ''' in escape sequence"},
 	{`'\U000000'`, token.RAWSTRING, 9, `'\U000000'`, "illegal character U+0027 ''' in escape sequence"},
 	{`'\U0000000'`, token.RAWSTRING, 10, `'\U0000000'`, "illegal character U+0027 ''' in escape sequence"},
-	{`'\U00000000'`, token.RAWSTRING, 0, `'\U00000000'`, ""},
+	{`'\U00000000'`, token.CHAR, 0, `'\U00000000'`, ""},
 	{`'\Uffffffff'`, token.RAWSTRING, 2, `'\Uffffffff'`, "escape sequence is invalid Unicode code point"},
 	{`'`, token.RAWSTRING, 0, `'`, "string literal not terminated"},
 	{`'\'`, token.RAWSTRING, 0, `'\'`, "string literal not terminated"},
 	{"'\n", token.RAWSTRING, 0, "'", "string literal not terminated"},
 	{"'\n   ", token.RAWSTRING, 0, "'", "string literal not terminated"},
+	{`r"\x"`, token.RAWSTRING, 0, `r"\x"`, ""},
+	{`r"\"`, token.RAWSTRING, 0, `r"\"`, ""},
+	{`r'\'`, token.RAWSTRING, 0, `r'\'`, ""},
+	{`r"abc`, token.RAWSTRING, 1, `r"abc`, "string literal not terminated"},
+	{"r'abc\n", token.RAWSTRING, 1, `r'abc`, "string literal not terminated"},
+	{"r", token.IDENT, 0, "r", ""},
+	{"r2", token.IDENT, 0, "r2", ""},
 	{`""`, token.STRING, 0, `""`, ""},
+	{`"$$"`, token.STRING, 0, `"$$"`, ""},
+	{`"\$"`, token.STRING, 0, `"\$"`, ""},
+	{`"$${v}"`, token.STRING, 0, `"$${v}"`, ""},
+	{`"\${v}"`, token.STRING, 0, `"\${v}"`, ""},
+	{`'\$'`, token.RAWSTRING, 2, `'\$'`, "unknown escape sequence"},
 	{`"abc`, token.STRING, 0, `"abc`, "string literal not terminated"},
 	{"\"abc\n", token.STRING, 0, `"abc`, "string literal not terminated"},
 	{"\"abc\n   ", token.STRING, 0, `"abc`, "string literal not terminated"},
@@ -139,6 +624,28 @@ var errors = []struct {
 	{"078e0", token.FLOAT, 0, "078e0", ""},
 	{"078", token.INT, 0, "078", "illegal octal number"},
 	{"07800000009", token.INT, 0, "07800000009", "illegal octal number"},
+	{"1e", token.FLOAT, 1, "1e", "exponent has no digits"},
+	{"1e+", token.FLOAT, 1, "1e+", "exponent has no digits"},
+	{"1e-x", token.FLOAT, 1, "1e-", "exponent has no digits"},
+	{"2.5E+", token.FLOAT, 3, "2.5E+", "exponent has no digits"},
+	{"1ei", token.IMAG, 1, "1ei", "exponent has no digits"},
+	{"2i", token.IMAG, 0, "2i", ""},
+	{"078i", token.IMAG, 0, "078i", ""},
+	{"3.14i", token.IMAG, 0, "3.14i", ""},
+	{"1e3i", token.IMAG, 0, "1e3i", ""},
+	{"3i+1", token.IMAG, 0, "3i", ""},
+	{"3ident", token.INT, 1, "3", "identifier immediately after numeric literal"},
+	{"2.5ix", token.FLOAT, 3, "2.5", "identifier immediately after numeric literal"},
+	{"1e3i2", token.FLOAT, 3, "1e3", "identifier immediately after numeric literal"},
+	{"123abc", token.INT, 3, "123", "identifier immediately after numeric literal"},
+	{"0x1G", token.INT, 3, "0x1", "identifier immediately after numeric literal"},
+	{".5_", token.FLOAT, 2, ".5", "identifier immediately after numeric literal"},
+	{"7ŝ", token.INT, 1, "7", "identifier immediately after numeric literal"},
+	{"2e3", token.FLOAT, 0, "2e3", ""},
+	{"2e3 x", token.FLOAT, 0, "2e3", ""},
+	{"0xfe", token.INT, 0, "0xfe", ""},
+	{"i", token.IDENT, 0, "i", ""},
+	{"i2", token.IDENT, 0, "i2", ""},
 	{"0x", token.INT, 0, "0x", "illegal hexadecimal number"},
 	{"0X", token.INT, 0, "0X", "illegal hexadecimal number"},
 	{"\"abc\x00def\"", token.STRING, 4, "\"abc\x00def\"", "illegal character NUL"},
@@ -154,3 +661,237 @@ func TestScanErrors(t *testing.T) {
 		checkError(t, fset, e.src, e.tok, e.pos, e.lit, e.err)
 	}
 }
+
+func TestLenientEscapes(t *testing.T) {
+	tests := []struct {
+		src   string
+		tok   token.Token
+		mode  Mode
+		err   string
+		warns string // offsets of the warnings
+	}{
+		{`"\q"`, token.STRING, LenientEscapes, "", "[2]"},
+		{`"\q"`, token.STRING, 0, "unknown escape sequence", "[]"},
+		{`"a\qb\n\8"`, token.STRING, LenientEscapes, "", "[3 8]"},
+		{`'\q'`, token.CHAR, LenientEscapes, "", "[2]"},
+		{`'\$'`, token.CHAR, LenientEscapes, "", "[2]"},
+		{`"\$"`, token.STRING, LenientEscapes, "", "[]"},
+		{`"\x0g"`, token.STRING, LenientEscapes, "illegal character U+0067 'g' in escape sequence", "[]"},
+		{`"\`, token.STRING, LenientEscapes, "escape sequence not terminated", "[]"},
+	}
+	for _, test := range tests {
+		var msg string
+		warns := []int{}
+		fset := token.NewFileSet()
+		var s Scanner
+		s.Warn = func(pos token.Position, m string) {
+			if m != "unknown escape sequence" {
+				t.Errorf("%s: got warning %q", test.src, m)
+			}
+			warns = append(warns, pos.Offset)
+		}
+		s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), func(_ token.Position, m string) {
+			if msg == "" {
+				msg = m
+			}
+		}, test.mode)
+		_, tok, lit := s.Scan()
+		if tok != test.tok || lit != test.src || msg != test.err {
+			t.Errorf("%s, mode %d: got %s %s, error %q; expected %s %s, error %q", test.src, test.mode, tok, lit, msg, test.tok, test.src, test.err)
+		}
+		if got := fmt.Sprint(warns); got != test.warns {
+			t.Errorf("%s, mode %d: got warnings at %s; expected %s", test.src, test.mode, got, test.warns)
+		}
+		if s.WarningCount != len(warns) {
+			t.Errorf("%s: got WarningCount %d, %d warnings", test.src, s.WarningCount, len(warns))
+		}
+	}
+}
+
+func TestForbid(t *testing.T) {
+	tests := []struct {
+		src  string
+		mode Mode
+		want string // tokens
+		errs string // positions and messages of the errors
+	}{
+		{"a // c\nb", ForbidComments, "IDENT COMMENT IDENT", "[1:3: comments not allowed here]"},
+		{"a /* c */ b", ForbidComments, "IDENT COMMENT IDENT", "[1:3: comments not allowed here]"},
+		{"a /* c */ b", ForbidComments | RetainTrivia, "IDENT IDENT", "[1:3: comments not allowed here]"},
+		{"a /* c */ b", ForbidRawStrings, "IDENT COMMENT IDENT", "[]"},
+		{"x 'abc' y", ForbidRawStrings, "IDENT RAWSTRING IDENT", "[1:3: raw strings not allowed here]"},
+		{"x r\"a\\b\" y", ForbidRawStrings, "IDENT RAWSTRING IDENT", "[1:3: raw strings not allowed here]"},
+		{"x 'a' \"b\"", ForbidRawStrings, "IDENT CHAR STRING", "[]"},
+		{"x 'abc' y", ForbidComments, "IDENT RAWSTRING IDENT", "[]"},
+		{"'a' // c", ForbidComments | ForbidRawStrings, "CHAR COMMENT", "[1:5: comments not allowed here]"},
+	}
+	for _, test := range tests {
+		fset := token.NewFileSet()
+		var list ErrorList
+		var s Scanner
+		s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), func(pos token.Position, msg string) { list.Add(pos, msg) }, test.mode)
+		var toks []string
+		for {
+			_, tok, _ := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			toks = append(toks, tok.String())
+		}
+		var errs []string
+		for _, e := range list {
+			errs = append(errs, e.Error())
+		}
+		if got := strings.Join(toks, " "); got != test.want {
+			t.Errorf("%q, mode %d: got tokens %s; expected %s", test.src, test.mode, got, test.want)
+		}
+		if got := fmt.Sprint(errs); got != test.errs || s.ErrorCount != len(list) {
+			t.Errorf("%q, mode %d: got errors %s (count %d); expected %s", test.src, test.mode, got, s.ErrorCount, test.errs)
+		}
+	}
+}
+
+func TestLenientBOM(t *testing.T) {
+	tests := []struct {
+		src   string
+		mode  Mode
+		toks  string
+		errs  string // positions of the errors "illegal byte order mark"
+		warns string // positions of the warnings "byte order mark ignored"
+	}{
+		{"\ufeff\ufeff", 0, "[ILLEGAL]", "[1:4]", "[]"},
+		{"\ufeff\ufeff", LenientBOM, "[]", "[]", "[1:4]"},
+		{"a\ufeff\ufeffb", 0, "[IDENT ILLEGAL ILLEGAL IDENT]", "[1:2 1:5]", "[]"},
+		{"a\ufeff\ufeffb", LenientBOM, "[IDENT IDENT]", "[]", "[1:2 1:5]"},
+		{"//\ufeff", 0, "[COMMENT]", "[1:3]", "[]"},
+		{"//\ufeff", LenientBOM, "[COMMENT]", "[]", "[1:3]"},
+		{"a\n\ufeffb", LenientBOM | ScanIndent, "[IDENT IDENT]", "[]", "[2:1]"},
+		{"a\n\ufeff\tb", LenientBOM | ScanIndent, "[IDENT INDENT IDENT DEDENT]", "[]", "[2:1]"},
+	}
+	for _, test := range tests {
+		fset := token.NewFileSet()
+		var errs, warns []string
+		var s Scanner
+		s.Warn = func(pos token.Position, msg string) {
+			if msg != "byte order mark ignored" {
+				t.Errorf("%q: got warning %q", test.src, msg)
+			}
+			warns = append(warns, pos.String())
+		}
+		s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), func(pos token.Position, msg string) {
+			if msg != "illegal byte order mark" {
+				t.Errorf("%q: got error %q", test.src, msg)
+			}
+			errs = append(errs, pos.String())
+		}, test.mode)
+		var toks []token.Token
+		for {
+			_, tok, _ := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			toks = append(toks, tok)
+		}
+		if got := fmt.Sprint(toks); got != test.toks {
+			t.Errorf("%q, mode %d: got tokens %s; expected %s", test.src, test.mode, got, test.toks)
+		}
+		if fmt.Sprint(errs) != test.errs || fmt.Sprint(warns) != test.warns || s.ErrorCount != len(errs) || s.WarningCount != len(warns) {
+			t.Errorf("%q, mode %d: got errors at %v, warnings at %v; expected %s, %s", test.src, test.mode, errs, warns, test.errs, test.warns)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	// A single scanner, reset for each source, behaves like a new one.
+	var s Scanner
+	var h errorCollector
+	eh := func(pos token.Position, msg string) {
+		h.cnt++
+		h.msg = msg
+		h.pos = pos
+	}
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", fset.Base(), 0), nil, eh)
+	for _, e := range errors {
+		h = errorCollector{}
+		s.Reset(fset.AddFile("", fset.Base(), len(e.src)), []byte(e.src))
+		_, tok, lit := s.Scan()
+		if tok != e.tok || tok != token.ILLEGAL && lit != e.lit {
+			t.Errorf("%q: got %s %q, expected %s %q", e.src, tok, lit, e.tok, e.lit)
+		}
+		cnt := 0
+		if e.err != "" {
+			cnt = 1
+		}
+		if h.cnt != cnt || h.msg != e.err || cnt > 0 && h.pos.Offset != e.pos {
+			t.Errorf("%q: got %d errors, last %q at %d; expected %q at %d", e.src, h.cnt, h.msg, h.pos.Offset, e.err, e.pos)
+		}
+	}
+}
+
+func TestVisualColumn(t *testing.T) {
+	const src = "\tfoo@"
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var list ErrorList
+	var s Scanner
+	s.Init(file, []byte(src), func(pos token.Position, msg string) { list.Add(pos, msg) })
+	for {
+		if _, tok, _ := s.Scan(); tok == token.EOF {
+			break
+		}
+	}
+	if len(list) != 1 {
+		t.Fatalf("got %d errors; expected 1", len(list))
+	}
+	pos := list[0].Pos
+	if pos.Column != 5 {
+		t.Errorf("got byte column %d; expected 5", pos.Column)
+	}
+	if col := file.VisualColumn(file.Pos(pos.Offset), []byte(src), 8); col != 12 {
+		t.Errorf("got column %d with tab width 8; expected 12", col)
+	}
+}
+
+func TestSafeErrorHandler(t *testing.T) {
+	pos := token.Position{Filename: "x", Offset: 1, Line: 1, Column: 2}
+	var h SafeErrorHandler
+	h.Call(pos, "ignored") // must not panic
+
+	var list ErrorList
+	h.Handler = func(pos token.Position, msg string) { list.Add(pos, msg) }
+	h.Call(pos, "msg")
+	if len(list) != 1 || list[0].Pos != pos || list[0].Msg != "msg" {
+		t.Errorf("got %v; expected 1 error %q at %s", list, "msg", pos)
+	}
+}
+
+func TestMultiErrorHandler(t *testing.T) {
+	const src = "@ #"
+	var l1, l2 ErrorList
+	eh := MultiErrorHandler(
+		func(pos token.Position, msg string) { l1.Add(pos, msg) },
+		nil,
+		func(pos token.Position, msg string) { l2.Add(pos, msg) },
+	)
+	fset := token.NewFileSet()
+	var s Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), eh)
+	for {
+		if _, tok, _ := s.Scan(); tok == token.EOF {
+			break
+		}
+	}
+	if len(l1) != 2 || len(l2) != 2 {
+		t.Fatalf("got %d and %d errors; expected 2 each", len(l1), len(l2))
+	}
+	for i := range l1 {
+		if *l1[i] != *l2[i] {
+			t.Errorf("error %d: got %s and %s", i, l1[i], l2[i])
+		}
+	}
+
+	// Without handlers, errors are ignored.
+	MultiErrorHandler()(token.Position{}, "ignored")
+	MultiErrorHandler(nil, nil)(token.Position{}, "ignored")
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner_test
+
+import (
+	"fmt"
+
+	"github.com/vastri/zolang/scanner"
+	"github.com/vastri/zolang/token"
+)
+
+func ExampleNewScanner() {
+	src := []byte("x := 1 +\n\t\"two\"")
+	s, file := scanner.NewScanner("example.zo", src, 0)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		fmt.Printf("%s\t%s\t%q\n", file.Position(pos), tok, lit)
+	}
+
+	// output:
+	// example.zo:1:1	IDENT	"x"
+	// example.zo:1:3	:=	""
+	// example.zo:1:6	INT	"1"
+	// example.zo:1:8	+	""
+	// example.zo:2:2	STRING	"\"two\""
+}
+
+func ExampleScanner_Scan_comments() {
+	// With RetainCommentText, the literal of a comment is its complete
+	// text, delimiters included, for instance to extract documentation.
+	src := []byte("/*\n * Package doc.\n */\n// Area of a circle.\narea := pi * r * r")
+	s, file := scanner.NewScanner("example.zo", src, scanner.RetainCommentText)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.COMMENT {
+			fmt.Printf("%s\t%q\n", file.Position(pos), lit)
+		}
+	}
+
+	// output:
+	// example.zo:1:1	"/*\n * Package doc.\n */"
+	// example.zo:4:1	"// Area of a circle."
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+
+	"github.com/vastri/zolang/token"
+)
+
+// Category is the syntax-highlight category of a token.
+type Category int
+
+// The list of categories.
+const (
+	Plain Category = iota // illegal tokens and anything not classified below
+	Keyword
+	Identifier
+	Number
+	String
+	Operator
+	Punctuation
+	Comment
+
+	numCategories
+)
+
+var categories = [...]string{
+	Plain:       "Plain",
+	Keyword:     "Keyword",
+	Identifier:  "Identifier",
+	Number:      "Number",
+	String:      "String",
+	Operator:    "Operator",
+	Punctuation: "Punctuation",
+	Comment:     "Comment",
+}
+
+// String returns the name of category c.
+func (c Category) String() string {
+	if 0 <= c && c < numCategories {
+		return categories[c]
+	}
+	return fmt.Sprintf("Category(%d)", int(c))
+}
+
+// Categorize returns the syntax-highlight category of tok.
+func Categorize(tok token.Token) Category {
+	switch tok {
+	case token.COMMENT:
+		return Comment
+	case token.IDENT, token.BLANK:
+		return Identifier
+	case token.BOOL, token.NULL:
+		return Keyword
+	case token.INT, token.FLOAT, token.IMAG:
+		return Number
+	case token.CHAR, token.STRING, token.RAWSTRING:
+		return String
+	case token.LPAREN, token.LBRACK, token.LBRACE, token.COMMA, token.PERIOD,
+		token.RPAREN, token.RBRACK, token.RBRACE, token.COLON, token.LBRACE_HASH:
+		return Punctuation
+	}
+	if tok.IsOperator() {
+		return Operator
+	}
+	return Plain
+}
+
+// A Span describes the byte range [Start, End) of a single token
+// in the source and its syntax-highlight category.
+//
+type Span struct {
+	Category Category
+	Start    int // offset of the first byte of the token
+	End      int // offset immediately after the last byte of the token
+}
+
+// A Highlighter classifies the tokens of a source text and renders
+// the text with a style per category. Styles are either CSS class
+// names, used by HTML, or ANSI escape sequences, used by ANSI.
+// The zero value for a Highlighter has no styles registered and
+// renders the text unchanged.
+//
+type Highlighter struct {
+	classes [numCategories]string // CSS class names
+	codes   [numCategories]string // ANSI escape sequences
+}
+
+// SetClass registers the CSS class name used by HTML for tokens
+// of category c. An empty class leaves such tokens unstyled.
+//
+func (h *Highlighter) SetClass(c Category, class string) {
+	h.classes[c] = class
+}
+
+// SetANSI registers the ANSI escape sequence used by ANSI for tokens
+// of category c, for instance "\x1b[32m". An empty code leaves such
+// tokens unstyled.
+//
+func (h *Highlighter) SetANSI(c Category, code string) {
+	h.codes[c] = code
+}
+
+// Highlight scans src and returns a span for each token, in source
+// order. The file must have been added to a file set with the size
+// of src. Whitespace between tokens is not covered by any span.
+// If src contains syntax errors, Highlight returns all spans and an
+// ErrorList describing the errors.
+//
+func (h *Highlighter) Highlight(src []byte, file *token.File) ([]Span, error) {
+	if file.Size() != len(src) {
+		return nil, fmt.Errorf("file size (%d) does not match src len (%d)", file.Size(), len(src))
+	}
+
+	var list ErrorList
+	var s Scanner
+	s.Init(file, src, func(pos token.Position, msg string) { list.Add(pos, msg) })
+
+	var spans []Span
+	for {
+		pos, tok, _, end := s.ScanExtended()
+		if tok == token.EOF {
+			break
+		}
+		spans = append(spans, Span{Categorize(tok), file.Offset(pos), file.Offset(end)})
+	}
+	return spans, list.Err()
+}
+
+// HTML returns src as HTML text, with each token of a category that
+// has a registered class wrapped in a span element of that class.
+// Errors are reported as by Highlight.
+//
+func (h *Highlighter) HTML(src []byte, file *token.File) (string, error) {
+	wrap := func(c Category) (string, string) {
+		if class := h.classes[c]; class != "" {
+			return `<span class="` + html.EscapeString(class) + `">`, "</span>"
+		}
+		return "", ""
+	}
+	escape := func(buf *bytes.Buffer, text []byte) {
+		buf.WriteString(html.EscapeString(string(text)))
+	}
+	return h.render(src, file, wrap, escape)
+}
+
+// ANSI returns src with each token of a category that has a registered
+// escape sequence preceded by that sequence and followed by a reset.
+// Errors are reported as by Highlight.
+//
+func (h *Highlighter) ANSI(src []byte, file *token.File) (string, error) {
+	wrap := func(c Category) (string, string) {
+		if code := h.codes[c]; code != "" {
+			return code, "\x1b[0m"
+		}
+		return "", ""
+	}
+	escape := func(buf *bytes.Buffer, text []byte) {
+		buf.Write(text)
+	}
+	return h.render(src, file, wrap, escape)
+}
+
+func (h *Highlighter) render(src []byte, file *token.File, wrap func(Category) (string, string), escape func(*bytes.Buffer, []byte)) (string, error) {
+	spans, err := h.Highlight(src, file)
+	if _, ok := err.(ErrorList); err != nil && !ok {
+		return "", err
+	}
+	var buf bytes.Buffer
+	offs := 0
+	for _, sp := range spans {
+		escape(&buf, src[offs:sp.Start]) // whitespace is never styled
+		before, after := wrap(sp.Category)
+		buf.WriteString(before)
+		escape(&buf, src[sp.Start:sp.End])
+		buf.WriteString(after)
+		offs = sp.End
+	}
+	escape(&buf, src[offs:])
+	return buf.String(), err
+}
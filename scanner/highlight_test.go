@@ -0,0 +1,132 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"testing"
+
+	"github.com/vastri/zolang/token"
+)
+
+func TestCategorize(t *testing.T) {
+	for _, e := range tokens {
+		var want Category
+		switch e.tok {
+		case token.COMMENT:
+			want = Comment
+		case token.IDENT, token.BLANK:
+			want = Identifier
+		case token.BOOL, token.NULL:
+			want = Keyword
+		case token.INT, token.FLOAT, token.IMAG:
+			want = Number
+		case token.CHAR, token.STRING, token.RAWSTRING:
+			want = String
+		case token.LPAREN, token.LBRACK, token.LBRACE, token.COMMA, token.PERIOD,
+			token.RPAREN, token.RBRACK, token.RBRACE, token.COLON, token.LBRACE_HASH:
+			want = Punctuation
+		default:
+			want = Operator
+		}
+		if got := Categorize(e.tok); got != want {
+			t.Errorf("%s: got category %s, expected %s", e.tok, got, want)
+		}
+	}
+	if got := Categorize(token.LBRACE_HASH); got != Punctuation {
+		t.Errorf("LBRACE_HASH: got category %s, expected %s", got, Punctuation)
+	}
+	if got := Categorize(token.ILLEGAL); got != Plain {
+		t.Errorf("ILLEGAL: got category %s, expected %s", got, Plain)
+	}
+}
+
+const highlightSrc = `// greet
+f(x, "a<b") >= 1.5 && true`
+
+func newHighlighter() *Highlighter {
+	var h Highlighter
+	h.SetClass(Keyword, "kw")
+	h.SetClass(Identifier, "id")
+	h.SetClass(Number, "num")
+	h.SetClass(String, "str")
+	h.SetClass(Operator, "op")
+	h.SetClass(Comment, "com")
+	return &h
+}
+
+func TestHighlight(t *testing.T) {
+	fset := token.NewFileSet()
+	src := []byte(highlightSrc)
+	spans, err := newHighlighter().Highlight(src, fset.AddFile("", fset.Base(), len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		c    Category
+		text string
+	}{
+		{Comment, "// greet"},
+		{Identifier, "f"},
+		{Punctuation, "("},
+		{Identifier, "x"},
+		{Punctuation, ","},
+		{String, `"a<b"`},
+		{Punctuation, ")"},
+		{Operator, ">="},
+		{Number, "1.5"},
+		{Operator, "&&"},
+		{Keyword, "true"},
+	}
+	if len(spans) != len(want) {
+		t.Fatalf("got %d spans, expected %d", len(spans), len(want))
+	}
+	for i, sp := range spans {
+		if text := string(src[sp.Start:sp.End]); sp.Category != want[i].c || text != want[i].text {
+			t.Errorf("span %d: got %s %q, expected %s %q", i, sp.Category, text, want[i].c, want[i].text)
+		}
+	}
+}
+
+func TestHighlightHTML(t *testing.T) {
+	fset := token.NewFileSet()
+	src := []byte(highlightSrc)
+	got, err := newHighlighter().HTML(src, fset.AddFile("", fset.Base(), len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `<span class="com">// greet</span>
+<span class="id">f</span>(<span class="id">x</span>, <span class="str">&#34;a&lt;b&#34;</span>) ` +
+		`<span class="op">&gt;=</span> <span class="num">1.5</span> <span class="op">&amp;&amp;</span> <span class="kw">true</span>`
+	if got != want {
+		t.Errorf("got\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestHighlightANSI(t *testing.T) {
+	var h Highlighter
+	h.SetANSI(Number, "\x1b[36m")
+	fset := token.NewFileSet()
+	src := []byte("x = 42")
+	got, err := h.ANSI(src, fset.AddFile("", fset.Base(), len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "x = \x1b[36m42\x1b[0m"; got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
+
+func TestHighlightErrors(t *testing.T) {
+	fset := token.NewFileSet()
+	src := []byte("a @ b")
+	got, err := newHighlighter().HTML(src, fset.AddFile("", fset.Base(), len(src)))
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 1 {
+		t.Fatalf("got error %v, expected one syntax error", err)
+	}
+	if want := `<span class="id">a</span> @ <span class="id">b</span>`; got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
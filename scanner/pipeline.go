@@ -0,0 +1,58 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import "github.com/vastri/zolang/token"
+
+// A TokenFilter transforms a token of a Pipeline. It returns the
+// possibly modified token and whether to drop it from the stream.
+//
+type TokenFilter func(pos token.Pos, tok token.Token, lit string) (token.Pos, token.Token, string, bool)
+
+// A Pipeline scans a source text and passes each token through a
+// sequence of filters.
+//
+type Pipeline struct {
+	s       Scanner
+	filters []TokenFilter
+}
+
+// NewPipeline returns a pipeline scanning src, the content of file,
+// as Scanner.Init does. Initially, the pipeline has no filters.
+//
+func NewPipeline(file *token.File, src []byte, err ErrorHandler) *Pipeline {
+	p := new(Pipeline)
+	p.s.Init(file, src, err)
+	return p
+}
+
+// AddFilter appends f to the filters of p and returns p.
+func (p *Pipeline) AddFilter(f TokenFilter) *Pipeline {
+	p.filters = append(p.filters, f)
+	return p
+}
+
+// Scan returns the next token that passes all filters of p, as
+// modified by them. Filters are applied in the order they were added,
+// each to the result of the previous one; a token dropped by a filter
+// is not passed to the following ones. The token.EOF of the source is
+// passed through the filters too but cannot be dropped, since it ends
+// the stream.
+//
+func (p *Pipeline) Scan() (token.Pos, token.Token, string) {
+	for {
+		pos, tok, lit := p.s.Scan()
+		eof := tok == token.EOF
+		drop := false
+		for _, f := range p.filters {
+			if pos, tok, lit, drop = f(pos, tok, lit); drop && !eof {
+				break
+			}
+		}
+		if !drop || eof {
+			return pos, tok, lit
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vastri/zolang/token"
+)
+
+func stripComments(pos token.Pos, tok token.Token, lit string) (token.Pos, token.Token, string, bool) {
+	return pos, tok, lit, tok == token.COMMENT
+}
+
+func upperIdents(pos token.Pos, tok token.Token, lit string) (token.Pos, token.Token, string, bool) {
+	if tok == token.IDENT {
+		lit = strings.ToUpper(lit)
+	}
+	return pos, tok, lit, false
+}
+
+func TestPipeline(t *testing.T) {
+	const src = "foo(x) // call\n/* b */ bar := \"s\""
+	tests := []struct {
+		filters []TokenFilter
+		want    string
+	}{
+		{nil, `IDENT "foo"|( ""|IDENT "x"|) ""|COMMENT ""|COMMENT ""|IDENT "bar"|:= ""|STRING "\"s\""|EOF ""`},
+		{[]TokenFilter{stripComments}, `IDENT "foo"|( ""|IDENT "x"|) ""|IDENT "bar"|:= ""|STRING "\"s\""|EOF ""`},
+		{[]TokenFilter{stripComments, upperIdents}, `IDENT "FOO"|( ""|IDENT "X"|) ""|IDENT "BAR"|:= ""|STRING "\"s\""|EOF ""`},
+		{[]TokenFilter{upperIdents, stripComments}, `IDENT "FOO"|( ""|IDENT "X"|) ""|IDENT "BAR"|:= ""|STRING "\"s\""|EOF ""`},
+		// Dropping everything still ends with EOF.
+		{[]TokenFilter{func(pos token.Pos, tok token.Token, lit string) (token.Pos, token.Token, string, bool) {
+			return pos, tok, lit, true
+		}}, `EOF ""`},
+	}
+	for i, test := range tests {
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(src))
+		p := NewPipeline(file, []byte(src), nil)
+		for _, f := range test.filters {
+			p.AddFilter(f)
+		}
+		var toks []string
+		var last token.Pos
+		for {
+			pos, tok, lit := p.Scan()
+			if pos < last {
+				t.Errorf("test %d: position %d of %s precedes %d", i, pos, tok, last)
+			}
+			last = pos
+			toks = append(toks, fmt.Sprintf("%s %q", tok, lit))
+			if tok == token.EOF {
+				break
+			}
+		}
+		if got := strings.Join(toks, "|"); got != test.want {
+			t.Errorf("test %d: got\n%s\nexpected\n%s", i, got, test.want)
+		}
+	}
+}
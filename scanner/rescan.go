@@ -0,0 +1,109 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/vastri/zolang/token"
+)
+
+// An Edit describes a change of a source text: the Len bytes starting
+// at Offset are replaced by Text.
+//
+type Edit struct {
+	Offset int    // offset of the first changed byte
+	Len    int    // number of bytes deleted
+	Text   []byte // bytes inserted
+}
+
+// rescanMargin bounds the number of bytes beyond the end of a token the
+// scanner reads to determine the token: the character following it and
+// the character after that.
+//
+const rescanMargin = 2 * utf8.UTFMax
+
+// Rescan returns the tokens of newSrc, the result of applying edit to
+// oldSrc, given the tokens prev of oldSrc as returned by Tokenize or
+// Rescan. The file is the file of newSrc; prev must have been scanned
+// in a file with the same base, for instance the only file of another
+// file set. Rescan sets the line table of file and scans only the part
+// of newSrc affected by the edit: it resumes scanning at a token before
+// the edit that the edit cannot change and stops as soon as a token
+// starts after the edit where a token of prev started, reusing the
+// remaining tokens of prev. The returned ErrorList contains the errors
+// of the rescanned part only.
+//
+// With ScanIndent or ParseLinePragmas, tokens depend on all of the
+// preceding source, and with CRLineEndings, so do the lines; Rescan
+// then scans newSrc entirely, like Tokenize.
+// Rescan panics if the edit does not fit the sizes of oldSrc and newSrc.
+//
+func Rescan(file *token.File, prev []TokenInfo, oldSrc, newSrc []byte, edit Edit, mode Mode) ([]TokenInfo, ErrorList) {
+	if edit.Offset < 0 || edit.Len < 0 || edit.Offset+edit.Len > len(oldSrc) ||
+		len(newSrc) != len(oldSrc)-edit.Len+len(edit.Text) {
+		panic("edit does not match source sizes")
+	}
+	if mode&(ScanIndent|ParseLinePragmas|CRLineEndings) != 0 {
+		return Tokenize(file, newSrc, mode)
+	}
+	base := file.Base()
+	offset := func(t TokenInfo) int { return int(t.Pos) - base }
+
+	// A token that starts at least rescanMargin bytes before the edit
+	// and the tokens before it are not affected by the edit. Since the
+	// scanner carries no state from one token to the next in the modes
+	// left, scanning can resume at the start of the last such token.
+	m := sort.Search(len(prev), func(i int) bool { return offset(prev[i])+rescanMargin > edit.Offset }) - 1
+
+	var list ErrorList
+	eh := func(pos token.Position, msg string) { list.Add(pos, msg) }
+	if len(newSrc) > 0 {
+		// Scanning may stop early; set the line table beforehand.
+		// An empty file keeps the line it was added with.
+		file.SetLinesForContent(newSrc)
+	}
+	var s Scanner
+	if m < 0 {
+		m = 0
+		s.InitMode(file, newSrc, eh, mode)
+	} else {
+		s.InitMode(file, newSrc, nil, mode)
+		s.err = eh
+		s.errs = nil
+		s.ErrorCount = 0
+		s.Seek(offset(prev[m]))
+	}
+
+	toks := make([]TokenInfo, m, len(prev)+len(edit.Text)/8+1)
+	copy(toks, prev[:m])
+	end := edit.Offset + len(edit.Text) // end of the edit in newSrc
+	delta := len(edit.Text) - edit.Len
+	j := m // index of the next token of prev that may resynchronize
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if offs := int(pos) - base; offs >= end {
+			// Beyond the edit, newSrc matches oldSrc shifted by delta.
+			// Once a token starts where one of prev did, the remaining
+			// tokens are those of prev.
+			for j < len(prev) && offset(prev[j]) < offs-delta {
+				j++
+			}
+			if j < len(prev) && offset(prev[j]) == offs-delta {
+				for _, t := range prev[j:] {
+					t.Pos += token.Pos(delta)
+					toks = append(toks, t)
+				}
+				break
+			}
+		}
+		toks = append(toks, TokenInfo{pos, tok, lit})
+	}
+	return toks, list
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/vastri/zolang/token"
+)
+
+// tokenizeNew tokenizes src as the only file of a new file set, so that
+// the positions of different sources are comparable.
+func tokenizeNew(src []byte) ([]TokenInfo, ErrorList, *token.File) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	toks, errs := Tokenize(file, src, 0)
+	return toks, errs, file
+}
+
+// rescanPieces are the building blocks of random sources and edits;
+// they are biased towards characters that change the extent of tokens.
+var rescanPieces = []string{
+	"a", "bc", "r", "1", "0x", "i", "e", ".", " ", "\n", "\t", "\"", "'", "\\",
+	"/", "*", "//", "/*", "*/", "=", ":", "!", "&", "世", "\xff", "\x00",
+}
+
+func randomText(r *rand.Rand, n int) []byte {
+	var b []byte
+	for i := 0; i < n; i++ {
+		b = append(b, rescanPieces[r.Intn(len(rescanPieces))]...)
+	}
+	return b
+}
+
+func checkRescan(t *testing.T, prev []TokenInfo, oldSrc []byte, edit Edit) ([]TokenInfo, []byte) {
+	newSrc := append(append(append([]byte{}, oldSrc[:edit.Offset]...), edit.Text...), oldSrc[edit.Offset+edit.Len:]...)
+	want, wantErrs, wantFile := tokenizeNew(newSrc)
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(newSrc))
+	got, errs := Rescan(file, prev, oldSrc, newSrc, edit, 0)
+	if len(got) != len(want) {
+		t.Fatalf("%q, edit %d %d %q: got %d tokens, expected %d", oldSrc, edit.Offset, edit.Len, edit.Text, len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%q, edit %d %d %q: token %d: got %v, expected %v", oldSrc, edit.Offset, edit.Len, edit.Text, i, got[i], want[i])
+		}
+	}
+	// The errors of the rescanned part are reported as by a full scan.
+	for _, e := range errs {
+		found := false
+		for _, w := range wantErrs {
+			found = found || *e == *w
+		}
+		if !found {
+			t.Errorf("%q, edit %d %d %q: unexpected error %s", oldSrc, edit.Offset, edit.Len, edit.Text, e)
+		}
+	}
+	if file.LineCount() != wantFile.LineCount() {
+		t.Errorf("%q: got %d lines, expected %d", newSrc, file.LineCount(), wantFile.LineCount())
+	}
+	return got, newSrc
+}
+
+func TestRescan(t *testing.T) {
+	edits := []struct {
+		src  string
+		edit Edit
+	}{
+		{"a b c", Edit{2, 1, []byte("x")}},
+		{"a b c", Edit{1, 1, nil}},              // joins two identifiers
+		{"x \"a b\" c d", Edit{2, 1, nil}},      // unterminates a string
+		{"x /* a */ b c", Edit{7, 2, nil}},      // unterminates a comment
+		{"x // a\nb c", Edit{6, 1, nil}},        // extends a comment
+		{"1 i2", Edit{1, 1, nil}},               // turns a number into an imaginary number
+		{"r 'a' b", Edit{1, 1, nil}},            // adds a raw string prefix
+		{"a\n世界 b", Edit{2, 1, []byte("\xe4")}}, // splits a multi-byte character
+		{"", Edit{0, 0, []byte("a b")}},
+		{"a b", Edit{0, 3, nil}},
+	}
+	for _, e := range edits {
+		prev, _, _ := tokenizeNew([]byte(e.src))
+		checkRescan(t, prev, []byte(e.src), e.edit)
+	}
+}
+
+func TestRescanRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n++ {
+		src := randomText(r, r.Intn(100))
+		if n == 0 {
+			src = source
+		}
+		prev, _, _ := tokenizeNew(src)
+		// Apply a sequence of edits, each to the result of the previous one.
+		for i := 0; i < 20; i++ {
+			offs := r.Intn(len(src) + 1)
+			edit := Edit{offs, r.Intn(len(src) - offs + 1), randomText(r, r.Intn(4))}
+			prev, src = checkRescan(t, prev, src, edit)
+		}
+	}
+}
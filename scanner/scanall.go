@@ -0,0 +1,139 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import "github.com/vastri/zolang/token"
+
+// ScanAll scans src from beginning to end and returns the tokens up
+// to but excluding token.EOF, their literal strings, and the number
+// of errors encountered. Position information is discarded; ScanAll
+// is meant for tests and fuzzing, where a compact and comparable
+// representation of the token stream is more useful than positions.
+//
+func ScanAll(src []byte) (toks []token.Token, lits []string, errs int) {
+	fset := token.NewFileSet()
+	var s Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil)
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		toks = append(toks, tok)
+		lits = append(lits, lit)
+	}
+	return toks, lits, s.ErrorCount
+}
+
+// A TokenInfo describes a token scanned by Tokenize.
+type TokenInfo struct {
+	Pos token.Pos   // position of the token
+	Tok token.Token // the token
+	Lit string      // literal string of the token, as returned by Scan
+}
+
+// Tokenize scans src, which is the content of file, using the given mode
+// and returns the tokens up to but excluding token.EOF. Errors encountered
+// are collected in the returned ErrorList, in the order they were found.
+//
+func Tokenize(file *token.File, src []byte, mode Mode) ([]TokenInfo, ErrorList) {
+	var list ErrorList
+	var s Scanner
+	s.InitMode(file, src, func(pos token.Position, msg string) { list.Add(pos, msg) }, mode)
+	// Typical sources average at least one token per 8 bytes
+	// including white space; start with that estimate.
+	toks := make([]TokenInfo, 0, len(src)/8+1)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		toks = append(toks, TokenInfo{pos, tok, lit})
+	}
+	return toks, list
+}
+
+// TokenizeSource scans src as the only file, named filename, of a new
+// file set and returns the tokens up to but excluding token.EOF and the
+// file set to resolve their positions. Errors are reported to err, if
+// not nil, and returned as an ErrorList, or nil if there are none.
+// TokenizeSource is Tokenize for callers that have no file set.
+//
+func TokenizeSource(filename string, src []byte, err ErrorHandler) ([]TokenInfo, *token.FileSet, error) {
+	fset := token.NewFileSet()
+	toks, list := Tokenize(fset.AddFile(filename, fset.Base(), len(src)), src, 0)
+	if err != nil {
+		for _, e := range list {
+			err(e.Pos, e.Msg)
+		}
+	}
+	return toks, fset, list.Err()
+}
+
+// Rewrite scans src, which is the content of file, calls fn for each
+// token up to but excluding token.EOF with its position, token, and
+// literal string as returned by Scan, and returns a copy of src in which
+// the source text of each token is replaced by the string fn returns.
+// If fn returns the literal unchanged, the token is copied as written,
+// byte for byte; so is the text between tokens. Syntax errors are
+// reported to err, if not nil, as by Scan.
+//
+func Rewrite(file *token.File, src []byte, err ErrorHandler, fn func(token.Pos, token.Token, string) string) []byte {
+	var s Scanner
+	s.Init(file, src, err)
+	out := make([]byte, 0, len(src))
+	offs := 0 // offset of the first byte of src not yet copied
+	for {
+		pos, tok, lit, end := s.ScanExtended()
+		if tok == token.EOF {
+			break
+		}
+		if text := fn(pos, tok, lit); text != lit {
+			start := file.Offset(pos)
+			out = append(out, src[offs:start]...)
+			out = append(out, text...)
+			offs = file.Offset(end)
+		}
+	}
+	return append(out, src[offs:]...)
+}
+
+// A NamedSource is a source text together with its file name.
+type NamedSource struct {
+	Name string // file name
+	Src  []byte // source text
+}
+
+// ScanConcat scans the sources parts in sequence, as if concatenated,
+// and returns their tokens up to but excluding token.EOF and the errors
+// encountered, as Tokenize does for a single source. Each part becomes a
+// new file of fset with the name of the part, so that the position of
+// each token refers to the part it originates from.
+//
+func ScanConcat(fset *token.FileSet, parts []NamedSource) ([]TokenInfo, ErrorList) {
+	var toks []TokenInfo
+	var list ErrorList
+	for _, part := range parts {
+		file := fset.AddFile(part.Name, fset.Base(), len(part.Src))
+		t, errs := Tokenize(file, part.Src, 0)
+		toks = append(toks, t...)
+		list = append(list, errs...)
+	}
+	return toks, list
+}
+
+// NewScanner returns a scanner for src, initialized with mode and no
+// error handler, together with the file it uses for position information.
+// The file is named name and is the only file of a new file set; the
+// positions of tokens resolve via the file's Position method. Errors are
+// counted in the scanner's ErrorCount field.
+//
+func NewScanner(name string, src []byte, mode Mode) (*Scanner, *token.File) {
+	fset := token.NewFileSet()
+	file := fset.AddFile(name, fset.Base(), len(src))
+	s := new(Scanner)
+	s.InitMode(file, src, nil, mode)
+	return s, file
+}
@@ -0,0 +1,18 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package scanner
+
+import "testing"
+
+func FuzzScanAll(f *testing.F) {
+	f.Add(source)
+	for _, e := range errors {
+		f.Add([]byte(e.src))
+	}
+	f.Fuzz(checkScanAll)
+}
@@ -0,0 +1,230 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"testing"
+
+	"github.com/vastri/zolang/token"
+)
+
+// checkScanAll verifies invariants that hold for the result of ScanAll
+// on any source, and that a scan with an error handler agrees with it,
+// reports every error to the handler, and yields token positions within
+// the file.
+func checkScanAll(t *testing.T, src []byte) {
+	toks, lits, errs := ScanAll(src)
+	if len(toks) != len(lits) {
+		t.Fatalf("%q: got %d tokens but %d literals", src, len(toks), len(lits))
+	}
+	if errs < 0 {
+		t.Errorf("%q: got negative error count %d", src, errs)
+	}
+	for i, tok := range toks {
+		switch {
+		case tok == token.EOF:
+			t.Errorf("%q: got EOF at index %d", src, i)
+		case tok == token.ILLEGAL && errs == 0:
+			t.Errorf("%q: got ILLEGAL token %q without error", src, lits[i])
+		case tok.IsLiteral() && lits[i] == "":
+			t.Errorf("%q: got %s with empty literal", src, tok)
+		}
+	}
+
+	fset := token.NewFileSet()
+	fset.AddFile("before", fset.Base(), 10) // positions don't start at 1
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s Scanner
+	handled := 0
+	s.Init(file, src, func(token.Position, string) { handled++ })
+	n := 0
+	for ; ; n++ {
+		pos, tok, lit := s.Scan()
+		if int(pos) < file.Base() || int(pos) > file.Base()+file.Size() {
+			t.Fatalf("%q: %s at position %d outside [%d, %d]", src, tok, pos, file.Base(), file.Base()+file.Size())
+		}
+		if tok == token.EOF {
+			break
+		}
+		if n >= len(toks) || tok != toks[n] || lit != lits[n] {
+			t.Fatalf("%q: with error handler, got token %d %s %q; ScanAll differs", src, n, tok, lit)
+		}
+	}
+	if n != len(toks) || s.ErrorCount != errs {
+		t.Errorf("%q: with error handler, got %d tokens and %d errors; expected %d and %d", src, n, s.ErrorCount, len(toks), errs)
+	}
+	if handled != s.ErrorCount {
+		t.Errorf("%q: got %d errors reported, ErrorCount %d", src, handled, s.ErrorCount)
+	}
+}
+
+// TestTokenize verifies that Tokenize returns the same tokens and errors
+// as a manual Scan loop.
+func TestTokenize(t *testing.T) {
+	srcs := [][]byte{source}
+	for _, e := range errors {
+		srcs = append(srcs, []byte(e.src))
+	}
+	for _, src := range srcs {
+		// Use a new file set each time so that positions are comparable.
+		fset := token.NewFileSet()
+		var want []TokenInfo
+		var wantErrs ErrorList
+		var s Scanner
+		s.Init(fset.AddFile("", fset.Base(), len(src)), src, func(pos token.Position, msg string) { wantErrs.Add(pos, msg) })
+		for {
+			pos, tok, lit := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			want = append(want, TokenInfo{pos, tok, lit})
+		}
+
+		fset = token.NewFileSet()
+		got, errs := Tokenize(fset.AddFile("", fset.Base(), len(src)), src, 0)
+		if len(got) != len(want) {
+			t.Errorf("%q: got %d tokens, expected %d", src, len(got), len(want))
+			continue
+		}
+		for i, w := range want {
+			if got[i] != w {
+				t.Errorf("%q: token %d: got %v, expected %v", src, i, got[i], w)
+			}
+		}
+		if len(errs) != len(wantErrs) {
+			t.Errorf("%q: got %d errors, expected %d", src, len(errs), len(wantErrs))
+			continue
+		}
+		for i, e := range wantErrs {
+			if *errs[i] != *e {
+				t.Errorf("%q: error %d: got %s, expected %s", src, i, errs[i], e)
+			}
+		}
+	}
+}
+
+func TestTokenizeSource(t *testing.T) {
+	for _, src := range [][]byte{source, []byte("a & b")} {
+		fset := token.NewFileSet()
+		file := fset.AddFile("src.zo", fset.Base(), len(src))
+		var s Scanner
+		s.Init(file, src, nil)
+
+		var handled ErrorList
+		got, gotSet, err := TokenizeSource("src.zo", src, func(pos token.Position, msg string) { handled.Add(pos, msg) })
+		for i := 0; ; i++ {
+			pos, tok, lit := s.Scan()
+			if tok == token.EOF {
+				if i != len(got) {
+					t.Errorf("%.20q: got %d tokens, expected %d", src, len(got), i)
+				}
+				break
+			}
+			if i >= len(got) || got[i] != (TokenInfo{pos, tok, lit}) {
+				t.Errorf("%.20q: token %d differs", src, i)
+				break
+			}
+			if p, want := gotSet.Position(got[i].Pos), fset.Position(pos); p != want {
+				t.Errorf("%.20q: token %d: got position %s, expected %s", src, i, p, want)
+			}
+		}
+		list, _ := err.(ErrorList)
+		if len(list) != s.ErrorCount || len(handled) != s.ErrorCount || err == nil && s.ErrorCount > 0 {
+			t.Errorf("%.20q: got error %v and %d errors handled, expected %d errors", src, err, len(handled), s.ErrorCount)
+		}
+	}
+}
+
+func TestScanAll(t *testing.T) {
+	toks, lits, errs := ScanAll(source)
+	if errs != 0 {
+		t.Errorf("found %d errors", errs)
+	}
+	if len(toks) != len(tokens) {
+		t.Fatalf("got %d tokens, expected %d", len(toks), len(tokens))
+	}
+	for i, e := range tokens {
+		elit := ""
+		if e.tok.IsLiteral() {
+			elit = e.lit
+		}
+		if toks[i] != e.tok || lits[i] != elit {
+			t.Errorf("token %d: got %s %q, expected %s %q", i, toks[i], lits[i], e.tok, elit)
+		}
+	}
+
+	// The seed corpus of FuzzScanAll.
+	checkScanAll(t, source)
+	for _, e := range errors {
+		checkScanAll(t, []byte(e.src))
+	}
+}
+
+func TestScanConcat(t *testing.T) {
+	fset := token.NewFileSet()
+	parts := []NamedSource{
+		{"a.zo", []byte("x := 1\n")},
+		{"b.zo", []byte("\ny & z")},
+	}
+	toks, errs := ScanConcat(fset, parts)
+	want := []struct {
+		tok token.Token
+		pos string
+	}{
+		{token.IDENT, "a.zo:1:1"},
+		{token.DEFINE, "a.zo:1:3"},
+		{token.INT, "a.zo:1:6"},
+		{token.IDENT, "b.zo:2:1"},
+		{token.ILLEGAL, "b.zo:2:3"},
+		{token.IDENT, "b.zo:2:5"},
+	}
+	if len(toks) != len(want) {
+		t.Fatalf("got %d tokens, expected %d", len(toks), len(want))
+	}
+	for i, w := range want {
+		if pos := fset.Position(toks[i].Pos).String(); toks[i].Tok != w.tok || pos != w.pos {
+			t.Errorf("token %d: got %s at %s, expected %s at %s", i, toks[i].Tok, pos, w.tok, w.pos)
+		}
+	}
+	if len(errs) != 1 || errs[0].Pos.String() != "b.zo:2:3" {
+		t.Errorf("got errors %v, expected one at b.zo:2:3", errs)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	const src = "foo := foo+1 // foo\n\tx.foo(\"foo\",foo)\r\n"
+	const want = "bar := bar+1 // foo\n\tx.bar(\"foo\",bar)\r\n"
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	n := 0
+	got := Rewrite(file, []byte(src), nil, func(pos token.Pos, tok token.Token, lit string) string {
+		n++
+		if tok == token.IDENT && lit == "foo" {
+			return "bar"
+		}
+		return lit
+	})
+	if string(got) != want {
+		t.Errorf("got %q; expected %q", got, want)
+	}
+	if n != 14 {
+		t.Errorf("fn called for %d tokens; expected 14", n)
+	}
+
+	// Without changes, the source is reproduced byte for byte, including
+	// malformed tokens.
+	for _, src := range []string{"", "  a\t\"b ", "@ 'x\n/* c", "\ufeffvar x = 0x"} {
+		fset := token.NewFileSet()
+		var list ErrorList
+		got := Rewrite(fset.AddFile("", fset.Base(), len(src)), []byte(src), func(pos token.Position, msg string) { list.Add(pos, msg) },
+			func(_ token.Pos, _ token.Token, lit string) string { return lit })
+		if string(got) != src {
+			t.Errorf("got %q; expected %q", got, src)
+		}
+		if src != "" && len(list) == 0 {
+			t.Errorf("%q: got no errors", src)
+		}
+	}
+}
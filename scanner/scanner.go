@@ -6,12 +6,20 @@
 package scanner
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 
 	"github.com/vastri/zolang/token"
+	"golang.org/x/text/unicode/norm"
 )
 
 // An ErrorHandler may be provided to Scanner.Init. If a syntax error is
@@ -21,24 +29,108 @@ import (
 //
 type ErrorHandler func(pos token.Position, msg string)
 
+// A SafeErrorHandler wraps an ErrorHandler that may be nil.
+// The zero value for a SafeErrorHandler ignores all errors.
+//
+type SafeErrorHandler struct {
+	Handler ErrorHandler // or nil
+}
+
+// Call calls h.Handler with pos and msg; it does nothing if h.Handler is nil.
+func (h SafeErrorHandler) Call(pos token.Position, msg string) {
+	if h.Handler != nil {
+		h.Handler(pos, msg)
+	}
+}
+
+// MultiErrorHandler returns an ErrorHandler that calls each non-nil
+// handler of handlers in turn, in the order given.
+//
+func MultiErrorHandler(handlers ...ErrorHandler) ErrorHandler {
+	var list []ErrorHandler
+	for _, h := range handlers {
+		if h != nil {
+			list = append(list, h)
+		}
+	}
+	return func(pos token.Position, msg string) {
+		for _, h := range list {
+			h(pos, msg)
+		}
+	}
+}
+
 // A Scanner holds the scanner's internal state while processing
 // a given text. It can be allocated as part of another data
-// structure but must be initialized via Init before use.
+// structure but must be initialized via Init or InitReader before use.
+// A Scanner may be reused for other texts, for instance from a
+// sync.Pool; Reset re-initializes it with the previous error handler
+// and mode. A Scanner must not be used by multiple goroutines
+// concurrently.
 //
 type Scanner struct {
 	// Immutable state.
-	file *token.File  // source file handle
-	dir  string       // directory portion of file.Name()
-	src  []byte       // source
-	err  ErrorHandler // error reporting; or nil
+	file  *token.File  // source file handle
+	dir   string       // directory portion of file.Name()
+	src   []byte       // source, or the window of it read so far from rd
+	buf   []byte       // buffer of the window, kept for reuse by InitReader
+	start int          // file offset of the start of the source
+	err   ErrorHandler // error reporting; or nil
+	mode  Mode         // scanning mode
 
 	// Scanning state.
 	ch       rune // current character
 	offset   int  // character offset
 	rdOffset int  // reading offset (position after current character)
 
+	// Reader state (InitReader only).
+	rd   io.Reader // source reader; or nil once exhausted
+	base int       // file offset of src[0]
+	keep int       // file offset of the first byte that must stay in src
+
+	// Indentation state (ScanIndent mode only).
+	indents    []string // indentation of the enclosing blocks; indents[0] == ""
+	lineStart  bool     // set if no token other than a comment was scanned on the current line
+	lineOffset int      // offset of the beginning of the current line
+
+	// Lookahead state (see PeekToken).
+	peeked  bool        // set if the next token was scanned ahead
+	peekPos token.Pos   // position of the token scanned ahead
+	peekTok token.Token // token scanned ahead
+	peekLit string      // literal of the token scanned ahead
+	peekAdj bool        // adjacency of the token scanned ahead
+	peekEnd token.Pos   // end position of the token scanned ahead
+	peekTrv string      // leading trivia of the token scanned ahead
+	peekOff int         // offset the token scanned ahead was scanned from
+
+	trivia string // leading trivia of the last token returned (RetainTrivia mode only)
+
+	// String adjacency state (see AdjacentToPrevious).
+	prevTok  token.Token // most recently scanned token
+	adjacent bool        // set if the last token returned follows a STRING immediately
+
+	// Error state (see ScanErr).
+	errs     ErrorList // errors reported since the last token was scanned
+	tokErrs  ErrorList // errors reported while scanning the last token
+	peekErrs ErrorList // errors reported while scanning the token scanned ahead
+
+	ctxCount int  // number of ScanContext calls since Init
+	progress int  // offset of the last Progress call, or -1 after EOF was reported
+	noLit    bool // set while scanning for ScanNoLit
+
+	intern map[string]string      // identifiers scanned (InternIdentifiers mode only); not reset by Init
+	onLine func(line, offset int) // see OnLine; not reset by Init
+
 	// Public state - ok to modify.
-	ErrorCount int // number of errors encountered
+	ErrorCount    int          // number of errors encountered
+	MaxErrors     int          // if > 0, number of errors after which scanning stops (see Scan); not reset by Init
+	MaxLiteralLen int          // if > 0, length in bytes beyond which a token is too long (see Scan); not reset by Init
+	Warn          ErrorHandler // warning reporting; or nil; not reset by Init
+	WarningCount  int          // number of warnings encountered
+
+	// Progress reporting (see Progress).
+	Progress         func(offset, total int) // if not nil, called as scanning advances; not reset by Init
+	ProgressInterval int                     // if > 0, bytes between Progress calls; default 1 MiB; not reset by Init
 }
 
 const bom = 0xFEFF // byte order mark, only permitted as very first character
@@ -47,35 +139,164 @@ const bom = 0xFEFF // byte order mark, only permitted as very first character
 // s.ch < 0 means end-of-file.
 //
 func (s *Scanner) next() {
-	if s.rdOffset < len(s.src) {
-		s.offset = s.rdOffset
-		if s.ch == '\n' {
-			s.file.AddLine(s.offset)
+	if s.rdOffset-s.base < len(s.src) || s.fill() {
+		offs := s.rdOffset
+		r, w := rune(s.src[offs-s.base]), 1
+		if s.ch == '\n' || s.ch == '\r' && r != '\n' && s.mode&CRLineEndings != 0 {
+			s.addLine(offs)
 		}
-		r, w := rune(s.src[s.rdOffset]), 1
 		switch {
 		case r == 0:
-			s.error(s.offset, "illegal character NUL")
+			s.error(offs, "illegal character NUL")
 		case r >= utf8.RuneSelf:
-			// Not ASCII.
-			r, w = utf8.DecodeRune(s.src[s.rdOffset:])
+			// Not ASCII; ASCII characters need no decoding.
+			s.fillRune()
+			r, w = utf8.DecodeRune(s.src[offs-s.base:])
 			if r == utf8.RuneError && w == 1 {
-				s.error(s.offset, "illegal UTF-8 encoding")
-			} else if r == bom && s.offset > 0 {
-				s.error(s.offset, "illegal byte order mark")
+				// Report a run of invalid bytes once; s.ch is
+				// still the previous character.
+				if !s.invalidByte() {
+					s.error(offs, "illegal UTF-8 encoding")
+				}
+			} else if r == bom && offs > 0 {
+				if s.mode&LenientBOM != 0 {
+					s.warn(offs, "byte order mark ignored")
+				} else {
+					s.error(offs, "illegal byte order mark")
+				}
 			}
 		}
+		s.offset = offs
 		s.rdOffset += w
 		s.ch = r
 	} else {
-		s.offset = len(s.src)
-		if s.ch == '\n' {
-			s.file.AddLine(s.offset)
+		s.offset = s.base + len(s.src)
+		if s.isLineEnd(s.ch) {
+			s.addLine(s.offset)
 		}
 		s.ch = -1 // eof
 	}
 }
 
+// addLine adds a line starting at offs to the file and, if the line is
+// new, reports it to the OnLine callback.
+//
+func (s *Scanner) addLine(offs int) {
+	if s.onLine == nil {
+		s.file.AddLine(offs)
+		return
+	}
+	n := s.file.LineCount()
+	s.file.AddLine(offs)
+	if s.file.LineCount() > n {
+		s.onLine(n+1, offs)
+	}
+}
+
+// invalidByte reports whether s.ch stands for a byte that is not valid
+// UTF-8, as opposed to an encoded U+FFFD.
+//
+func (s *Scanner) invalidByte() bool {
+	return s.ch == utf8.RuneError && s.rdOffset-s.offset == 1
+}
+
+// isLineEnd reports whether ch ends a line: '\n', and '\r' in
+// CRLineEndings mode. A "\r\n" sequence ends a single line.
+//
+func (s *Scanner) isLineEnd(ch rune) bool {
+	return ch == '\n' || ch == '\r' && s.mode&CRLineEndings != 0
+}
+
+// minRead is the minimum number of bytes the buffer of a reading
+// scanner has room for when calling Read.
+//
+const minRead = 4096
+
+// read discards the bytes of the source window before s.keep, reads
+// more of the source from s.rd and grows the file accordingly. A read
+// error other than io.EOF is reported; either way, s.rd is cleared
+// after an error since the source ends there.
+//
+func (s *Scanner) read() {
+	if d := s.keep - s.base; d > 0 {
+		s.src = s.src[:copy(s.src, s.src[d:])]
+		s.base = s.keep
+	}
+	if cap(s.src)-len(s.src) < minRead {
+		buf := make([]byte, len(s.src), 2*cap(s.src)+minRead)
+		copy(buf, s.src)
+		s.src = buf
+		s.buf = buf
+	}
+	n, err := s.rd.Read(s.src[len(s.src):cap(s.src)])
+	if n > 0 {
+		s.src = s.src[:len(s.src)+n]
+		s.file.SetSize(s.base + len(s.src))
+	}
+	if err != nil {
+		s.rd = nil
+		if err != io.EOF {
+			s.error(s.base+len(s.src), "read error: "+err.Error())
+		}
+	}
+}
+
+// fill reads from s.rd until the source window extends beyond
+// s.rdOffset, and reports whether it does.
+//
+func (s *Scanner) fill() bool {
+	for s.rd != nil && s.rdOffset-s.base >= len(s.src) {
+		s.read()
+	}
+	return s.rdOffset-s.base < len(s.src)
+}
+
+// fillRune reads from s.rd until the source window holds the complete
+// UTF-8 encoding of the character at s.rdOffset, or the source ends.
+//
+func (s *Scanner) fillRune() {
+	for s.rd != nil && !utf8.FullRune(s.src[s.rdOffset-s.base:]) {
+		s.read()
+	}
+}
+
+// text returns the source bytes between the file offsets from and to.
+// For a reading scanner, the bytes are only valid until the next call
+// of Scan.
+//
+func (s *Scanner) text(from, to int) []byte {
+	return s.src[from-s.base : to-s.base]
+}
+
+// A Mode value is a set of flags (or 0).
+// They control scanner behavior.
+//
+type Mode uint
+
+const (
+	ScanIndent           Mode = 1 << iota // emit INDENT and DEDENT tokens for changes in line indentation
+	RetainCommentText                     // return the text of comments as COMMENT literal
+	StripCommentCR                        // remove carriage returns from retained comment text
+	ParseLinePragmas                      // register //line and /*line*/ comments with the file
+	RetainTrivia                          // skip comments like white space and record both; see LeadingTrivia
+	WarnMixedIndent                       // warn about lines indented with both tabs and spaces
+	AllowHash                             // scan '#' as token.HASH instead of an illegal character
+	AllowSetLiterals                      // scan "#{" as token.LBRACE_HASH, opening a set literal
+	LenientEscapes                        // accept unknown escape sequences in quoted literals as written
+	InternIdentifiers                     // return the same string for repeated identifier literals
+	NilKeyword                            // scan nil instead of null as token.NULL
+	NormalizeIdentifiers                  // return identifier literals in Unicode normalization form NFC
+	CRLineEndings                         // recognize "\r" and "\r\n" as line endings besides "\n"
+	OctalPrefix                           // scan "0o" and "0O" prefixed octal ints and warn about leading zeros
+	ForbidComments                        // report comments as errors
+	ForbidRawStrings                      // report raw strings as errors
+	LenientBOM                            // warn about byte order marks after the start of the file and skip them
+	ASCIIIdentsOnly                       // report non-ASCII characters in identifiers as errors
+	WarnNonNFC                            // warn about identifiers not in Unicode normalization form NFC
+	WarnConfusables                       // warn about identifiers mixing Latin and confusable letters
+	SkipShebang                           // skip a "#!" line at the start of the file
+)
+
 // Init prepares the scanner s to tokenize the text src by setting the
 // scanner at the beginning of src. The scanner uses the file set file
 // for position information and it adds line information for each line.
@@ -91,39 +312,515 @@ func (s *Scanner) next() {
 // of the file.
 //
 func (s *Scanner) Init(file *token.File, src []byte, err ErrorHandler) {
-	// Explicitly initialize all fields since a scanner may be reused.
+	s.InitMode(file, src, err, 0)
+}
+
+// InitMode is like Init but tokenizes src as determined by mode; Init
+// uses mode 0, where blocks are delimited by braces only. If the
+// ScanIndent mode bit is set, Scan also reports the change of indentation
+// at the beginning of each line that contains a token other than a
+// comment: a line indented deeper than the enclosing block yields a
+// token.INDENT, and a line indented less yields a token.DEDENT for each
+// block it closes. The indentation of a line must extend or match the
+// indentation of the enclosing blocks byte for byte, so that tabs and
+// spaces cannot be mixed at the same level. A line whose first token
+// follows a comment on that line, such as the end of a multi-line
+// comment, keeps the indentation of the enclosing block. At EOF, a
+// token.DEDENT is reported for each block still open.
+//
+// The RetainCommentText and StripCommentCR mode bits control the literal
+// returned for comments; see Scan.
+//
+// By default, only '\n' ends a line; a '\r' preceding it is part of
+// the line. If the CRLineEndings mode bit is set, "\r\n" and a lone
+// '\r' end a line as well, for the line table of the file, for
+// indentation (ScanIndent mode), and for literals ending at the end of
+// a line: the literal of a //-style comment or an unterminated string
+// excludes the line ending in either form.
+//
+// If the OctalPrefix mode bit is set, "0o" or "0O" followed by octal
+// digits is scanned as a token.INT, and an octal int without the prefix,
+// such as 0123, is reported as warning "leading zero in decimal literal;
+// use 0o prefix for octal". The int 0 and floats such as 0123.5 are not
+// affected. Warnings are reported as for WarnMixedIndent.
+//
+// The ForbidComments and ForbidRawStrings mode bits restrict the source
+// for languages embedding zolang: a comment is reported as error
+// "comments not allowed here", and a token.RAWSTRING as error "raw
+// strings not allowed here", at the start of the construct. It is
+// scanned as usual otherwise, so that scanning continues after it.
+//
+// If the NormalizeIdentifiers mode bit is set, identifiers may contain
+// combining marks after their first character, and their literals are
+// returned in Unicode normalization form NFC, so that the composed and
+// the decomposed spelling of an identifier yield the same literal. The
+// position of an identifier still refers to its spelling in the source.
+//
+// If the WarnNonNFC mode bit is set, identifiers may contain combining
+// marks as well, and an identifier not in normalization form NFC, such
+// as a decomposed spelling, is reported as warning "identifier not in
+// Unicode normal form NFC" at its position; warnings are reported as
+// for WarnMixedIndent. Unless NormalizeIdentifiers is set as well, the
+// literal is the identifier as written.
+//
+// If the WarnConfusables mode bit is set, an identifier containing a
+// Latin letter and a letter of another script that resembles an ASCII
+// letter, such as the Cyrillic а in pаth, is reported as warning
+// "identifier contains mixed-script confusable character U+0430 (looks
+// like 'a')" at the position of the first such letter, naming the ASCII
+// letter it resembles; see CheckConfusables.
+//
+// If the ASCIIIdentsOnly mode bit is set, an identifier containing a
+// letter or digit that is not ASCII, such as the Cyrillic а in pаth,
+// is reported as error "non-ASCII character U+0430 in identifier" at
+// the first such character. The identifier is scanned as usual.
+//
+// If the InternIdentifiers mode bit is set, the literals of identifiers,
+// including true and false, are interned: repeated identifiers yield the
+// same string, which saves an allocation for each repetition. The table
+// of interned identifiers is kept when the scanner is initialized again,
+// so that identifiers repeated across the files scanned with the same
+// Scanner are shared as well.
+//
+// If the LenientEscapes mode bit is set, an unknown escape sequence such
+// as \q in a string or character literal is not an error; it stands for
+// the backslash and the character following it, and the literal keeps
+// both. It is reported as warning "unknown escape sequence" instead;
+// warnings are reported as for WarnMixedIndent. Malformed numeric
+// escapes are reported as errors as usual.
+//
+// If the WarnMixedIndent mode bit is set, a line that contains anything
+// but white space and whose leading white space contains both tabs and
+// spaces is reported as warning "mixed tabs and spaces in indentation".
+// Warnings are reported via the Scanner field Warn, if not nil, and
+// counted in WarningCount; they do not count as errors.
+//
+// A byte order mark is only permitted as the very first character of
+// src; elsewhere, it is reported as error "illegal byte order mark".
+// If the LenientBOM mode bit is set, it is reported as warning "byte
+// order mark ignored" instead and skipped like white space between
+// tokens; it does not count as indentation either. Inside comments and
+// literals, it remains part of the text.
+//
+// If the SkipShebang mode bit is set, a first line starting with "#!",
+// possibly after a leading byte order mark, is skipped like a comment
+// but not returned as such, so that scripts with an interpreter line
+// scan cleanly; this takes precedence over AllowHash. The line still
+// counts in positions: the first token after it is on line 2.
+//
+// If the RetainTrivia mode bit is set, Scan skips comments like white
+// space instead of returning COMMENT tokens, and records the text it
+// skipped before each token; see LeadingTrivia.
+//
+// If the ParseLinePragmas mode bit is set, a comment of the form
+//
+//      //line filename:line
+//
+// sets the position of the following line to the given filename and line,
+// and a comment of the form
+//
+//      /*line filename:line*/
+//
+// does the same for the line containing the end of the comment, via
+// token.File.AddLineInfo. A relative filename is interpreted relative
+// to the directory of the file being scanned. Malformed line comments
+// are ignored.
+//
+func (s *Scanner) InitMode(file *token.File, src []byte, err ErrorHandler, mode Mode) {
+	if e := checkSource(file, src); e != nil {
+		panic(e.Error())
+	}
+	s.src = src
+	s.rd = nil
+	s.init(file, 0, err, mode)
+}
+
+// A ContextErrorHandler may be provided to Scanner.InitWithContext.
+// It is called like an ErrorHandler, with the text of the source line
+// containing the error in addition, excluding the line ending.
+//
+type ContextErrorHandler func(pos token.Position, msg, lineText string)
+
+// InitWithContext is like InitMode but reports errors to err, if not nil,
+// together with the text of the offending line, for instance to show it
+// with a caret at the error column. Lines end as defined by mode (see
+// CRLineEndings); the line ending is not part of the text.
+//
+func (s *Scanner) InitWithContext(file *token.File, src []byte, err ContextErrorHandler, mode Mode) {
+	var eh ErrorHandler
+	if err != nil {
+		eh = func(pos token.Position, msg string) { err(pos, msg, s.lineText(pos.Offset)) }
+	}
+	s.InitMode(file, src, eh, mode)
+}
+
+// lineText returns the text of the source line containing the file
+// offset offs, excluding the line ending.
+//
+func (s *Scanner) lineText(offs int) string {
+	start, end := offs-s.base, offs-s.base
+	for start > 0 && !s.isLineEnd(rune(s.src[start-1])) {
+		start--
+	}
+	for end < len(s.src) && !s.isLineEnd(rune(s.src[end])) {
+		end++
+	}
+	if end > start && s.src[end-1] == '\r' && end < len(s.src) && s.src[end] == '\n' {
+		end-- // "\r\n" line ending
+	}
+	return string(s.src[start:end])
+}
+
+// InitChecked is like InitMode but returns an error instead of panicking
+// if file is nil or its size does not match the src size. It also
+// rejects a src starting with a UTF-16 byte order mark, which InitMode
+// would scan as illegal UTF-8 (see InitDetect for UTF-16 sources);
+// a UTF-8 byte order mark is skipped as by Init. If InitChecked
+// returns an error, s is left unchanged.
+//
+func (s *Scanner) InitChecked(file *token.File, src []byte, err ErrorHandler, mode Mode) error {
+	if e := checkSource(file, src); e != nil {
+		return e
+	}
+	if len(src) >= 2 && (src[0] == 0xfe && src[1] == 0xff || src[0] == 0xff && src[1] == 0xfe) {
+		return fmt.Errorf("src starts with a UTF-16 byte order mark; expected UTF-8")
+	}
+	s.src = src
+	s.rd = nil
+	s.init(file, 0, err, mode)
+	return nil
+}
+
+// InitDetect adds a file named filename to fset and prepares s to
+// tokenize src as its content, like InitMode. If src starts with a UTF-16
+// byte order mark, little or big endian, src is transcoded to UTF-8
+// first: the file has the size of the transcoded text, without the
+// byte order mark, and positions refer to that text. Unpaired
+// surrogates and a trailing odd byte are transcoded to the Unicode
+// replacement character U+FFFD. Any other src, including UTF-8 with
+// or without a byte order mark, is scanned exactly as by InitMode.
+// InitDetect returns the file and the text scanned.
+//
+func (s *Scanner) InitDetect(fset *token.FileSet, filename string, src []byte, err ErrorHandler, mode Mode) (*token.File, []byte) {
+	if len(src) >= 2 {
+		switch {
+		case src[0] == 0xff && src[1] == 0xfe:
+			src = decodeUTF16(src[2:], binary.LittleEndian)
+		case src[0] == 0xfe && src[1] == 0xff:
+			src = decodeUTF16(src[2:], binary.BigEndian)
+		}
+	}
+	file := fset.AddFile(filename, fset.Base(), len(src))
+	s.InitMode(file, src, err, mode)
+	return file, src
+}
+
+// A SourceFilter transforms the content src of the file named name
+// before it is scanned, for instance to convert its character set or
+// to strip a header. It returns the text to scan, or an error.
+//
+type SourceFilter func(name string, src []byte) ([]byte, error)
+
+// InitFilter is like InitDetect but transforms src with filter instead
+// of detecting its encoding: the file is added with the size of the
+// filtered text, and positions refer to that text. If filter returns an
+// error, it is reported at the beginning of the file, which is then
+// empty. InitFilter returns the file and the text scanned.
+//
+func (s *Scanner) InitFilter(fset *token.FileSet, filename string, src []byte, filter SourceFilter, err ErrorHandler, mode Mode) (*token.File, []byte) {
+	text, e := filter(filename, src)
+	if e != nil {
+		text = nil
+	}
+	file := fset.AddFile(filename, fset.Base(), len(text))
+	s.InitMode(file, text, err, mode)
+	if e != nil {
+		s.error(0, "source filter error: "+e.Error())
+	}
+	return file, text
+}
+
+// decodeUTF16 returns the UTF-8 encoding of the UTF-16 text b.
+func decodeUTF16(b []byte, order binary.ByteOrder) []byte {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[2*i:])
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(b))
+	for _, r := range utf16.Decode(u) {
+		buf.WriteRune(r)
+	}
+	if len(b)%2 != 0 {
+		buf.WriteRune(utf8.RuneError)
+	}
+	return buf.Bytes()
+}
+
+// checkSource reports whether src may be scanned as the content of file.
+func checkSource(file *token.File, src []byte) error {
+	if file == nil {
+		return fmt.Errorf("nil file")
+	}
 	if file.Size() != len(src) {
-		panic(fmt.Sprintf("file size (%d) does not match src len (%d)", file.Size(), len(src)))
+		return fmt.Errorf("file size (%d) does not match src len (%d)", file.Size(), len(src))
+	}
+	return nil
+}
+
+// InitReader prepares the scanner s to tokenize the text read from r,
+// like InitMode does for a text held in memory. The file must have been
+// added with size 0 as the most recently added file of its file set;
+// its size grows via token.File.SetSize as r is read. The scanner
+// keeps only the part of the source needed for the current token in
+// memory. Tokens, positions, and errors are the same as when scanning
+// the entire text with InitMode. A read error other than io.EOF is
+// reported via err and ends the source.
+//
+func (s *Scanner) InitReader(file *token.File, r io.Reader, err ErrorHandler, mode Mode) {
+	if file.Size() != 0 {
+		panic(fmt.Sprintf("file size (%d) must be 0 for a reader", file.Size()))
+	}
+	s.src = s.buf[:0]
+	s.rd = r
+	s.init(file, 0, err, mode)
+}
+
+// NewRangeScanner returns a scanner for the part src[start:end] of src,
+// the content of file, as Init prepares it for all of src.
+// Positions refer to the entire file; for correct line information,
+// NewRangeScanner sets the lines of file for src. The scanner scans the
+// range as if src ended at end: a token crossing end is cut off there,
+// and Scan returns token.EOF at end and on any further call.
+// NewRangeScanner panics if the file size does not match the src size
+// or if the range is not within src.
+//
+func NewRangeScanner(file *token.File, src []byte, start, end int, err ErrorHandler) *Scanner {
+	if e := checkSource(file, src); e != nil {
+		panic(e.Error())
+	}
+	if start < 0 || start > end || end > len(src) {
+		panic(fmt.Sprintf("invalid range [%d:%d] of src len (%d)", start, end, len(src)))
 	}
+	if len(src) > 0 {
+		file.SetLinesForContent(src)
+	}
+	s := new(Scanner)
+	s.src = src[start:end]
+	s.init(file, start, err, 0)
+	return s
+}
+
+// Reset prepares s to tokenize the text src like InitMode does, with the
+// error handler and mode s was initialized with last. It reuses the
+// memory allocated by s for the previous text where possible.
+//
+func (s *Scanner) Reset(file *token.File, src []byte) {
+	s.InitMode(file, src, s.err, s.mode)
+}
+
+// init initializes s for scanning s.src, which starts at the file
+// offset base.
+//
+func (s *Scanner) init(file *token.File, base int, err ErrorHandler, mode Mode) {
+	// Explicitly initialize all fields since a scanner may be reused.
 	s.file = file
-	s.dir, _ = filepath.Split(file.Name())
-	s.src = src
+	if name := file.Name(); name != "" {
+		s.dir, _ = filepath.Split(name)
+	} else {
+		s.dir = ""
+	}
 	s.err = err
+	s.mode = mode
 
 	s.ch = ' '
-	s.offset = 0
-	s.rdOffset = 0
+	s.offset = base
+	s.rdOffset = base
+	s.base = base
+	s.start = base
+	s.keep = base
 	s.ErrorCount = 0
+	s.WarningCount = 0
+	s.errs = nil
 
 	s.next()
+	s.skipPrelude()
+
+	s.indents = append(s.indents[:0], "")
+	s.lineStart = true
+	s.lineOffset = s.offset
+
+	s.peeked = false
+	s.prevTok = token.ILLEGAL
+	s.adjacent = false
+	s.trivia = ""
+	s.ctxCount = 0
+	s.progress = base
+}
+
+// skipPrelude skips a byte order mark and, in SkipShebang mode, a "#!"
+// line, in this order, if the scanner is at the beginning of the file.
+// The line end following a "#!" line is not skipped.
+//
+func (s *Scanner) skipPrelude() {
+	if s.offset != 0 {
+		return
+	}
 	if s.ch == bom {
 		s.next() // ignore BOM at file beginning
 	}
+	if s.mode&SkipShebang != 0 && s.ch == '#' && s.peek() == '!' {
+		for !s.isLineEnd(s.ch) && s.ch >= 0 {
+			s.next()
+		}
+	}
+}
+
+// Peek returns the character immediately following the most recently
+// scanned token, or the first character of the source if Scan has not
+// been called yet, without advancing the scanner. The character may be
+// white space preceding the next token. At the end of the source, Peek
+// returns -1. Peek never reports an error.
+//
+func (s *Scanner) Peek() rune {
+	return s.ch
+}
+
+// Offset returns the offset of the character Peek returns, which is
+// where scanning continues: the offset immediately after the most
+// recently scanned token, or of the first character of the source if
+// Scan has not been called yet. The offset does not account for white
+// space preceding the next token. At the end of the source, Offset
+// returns the source size.
+//
+func (s *Scanner) Offset() int {
+	return s.offset
+}
+
+// Pos returns the position of the offset returned by Offset.
+func (s *Scanner) Pos() token.Pos {
+	return s.file.Pos(s.offset)
+}
+
+// Source returns the source text the scanner was initialized with, not
+// a copy: the src passed to Init, the range of it for NewRangeScanner,
+// and the transcoded or filtered text for InitDetect and InitFilter.
+// For a scanner initialized with InitReader, it is the part of the
+// source read so far that the scanner still holds.
+//
+func (s *Scanner) Source() []byte {
+	return s.src
+}
+
+// Remaining returns the number of source bytes not scanned yet: from the
+// offset returned by Offset to the end of the source. For a scanner
+// initialized with InitReader, only the bytes read so far count.
+//
+func (s *Scanner) Remaining() int {
+	return s.base + len(s.src) - s.offset
+}
+
+// ScannedBytes returns the number of source bytes scanned so far: from
+// the start of the source, or of the range for NewRangeScanner, to the
+// offset returned by Offset. Except for a scanner initialized with
+// InitReader, Remaining() + ScannedBytes() == len(s.Source()).
+//
+func (s *Scanner) ScannedBytes() int {
+	return s.offset - s.start
+}
+
+// Filename returns the name of the file the scanner was initialized with.
+func (s *Scanner) Filename() string {
+	return s.file.Name()
+}
+
+// OnLine sets fn to be called whenever the scanner adds a line to the
+// line table of its file, with the number of the new line and the file
+// offset it starts at; a nil fn removes the callback. Lines already in
+// the table, for instance when scanning part of a file again after a
+// Seek, are not reported. The callback is kept by Init.
+//
+func (s *Scanner) OnLine(fn func(line int, offset int)) {
+	s.onLine = fn
+}
+
+// peek returns the byte following the most recently read character
+// without advancing the scanner. If the scanner is at EOF, peek
+// returns 0.
+//
+func (s *Scanner) peek() byte {
+	if s.rdOffset-s.base < len(s.src) || s.fill() {
+		return s.src[s.rdOffset-s.base]
+	}
+	return 0
+}
+
+// tooManyErrors reports whether the scanner reached s.MaxErrors.
+func (s *Scanner) tooManyErrors() bool {
+	return s.MaxErrors > 0 && s.ErrorCount >= s.MaxErrors
+}
+
+// peekRune returns the character following the current character
+// without advancing the scanner. If the scanner is at EOF, peekRune
+// returns -1.
+//
+func (s *Scanner) peekRune() rune {
+	if s.rdOffset-s.base < len(s.src) || s.fill() {
+		r := rune(s.src[s.rdOffset-s.base])
+		if r >= utf8.RuneSelf {
+			s.fillRune()
+			r, _ = utf8.DecodeRune(s.src[s.rdOffset-s.base:])
+		}
+		return r
+	}
+	return -1
+}
+
+func (s *Scanner) warn(offs int, msg string) {
+	if s.Warn != nil {
+		s.Warn(s.file.Position(s.file.Pos(offs)), msg)
+	}
+	s.WarningCount++
 }
 
 func (s *Scanner) error(offs int, msg string) {
-	if s.err != nil {
-		s.err(s.file.Position(s.file.Pos(offs)), msg)
+	if s.tooManyErrors() {
+		return
 	}
+	pos := s.file.Position(s.file.Pos(offs))
+	s.report(pos, msg)
 	s.ErrorCount++
+	if s.tooManyErrors() {
+		// Tell the client why the errors end here.
+		s.report(pos, "too many errors")
+	}
 }
 
-func (s *Scanner) scanComment() {
+// report passes an error to the error handler, if any, and records
+// it for ScanErr.
+//
+func (s *Scanner) report(pos token.Position, msg string) {
+	if s.err != nil {
+		s.err(pos, msg)
+	}
+	s.errs.Add(pos, msg)
+}
+
+func (s *Scanner) scanComment() string {
 	// Initial '/' already consumed; s.ch == '/' || s.ch == '*'.
+	offs := s.offset - 1 // position of initial '/'
+	hasCR := false
+	if s.mode&ForbidComments != 0 {
+		s.error(offs, "comments not allowed here")
+	}
+
 	if s.ch == '/' {
 		// Single-line comment.
 		s.next()
-		for s.ch != '\n' && s.ch >= 0 {
+		for !s.isLineEnd(s.ch) && s.ch >= 0 {
+			if s.ch == '\r' {
+				hasCR = true
+			}
 			s.next()
 		}
 	} else {
@@ -131,13 +828,137 @@ func (s *Scanner) scanComment() {
 		s.next()
 		for s.ch >= 0 {
 			ch := s.ch
+			if ch == '\r' {
+				hasCR = true
+			}
 			s.next()
+			if ch == '\n' || ch == '\r' && s.ch != '\n' && s.mode&CRLineEndings != 0 {
+				s.lineOffset = s.offset
+			}
 			if ch == '*' && s.ch == '/' {
 				s.next()
 				break
 			}
 		}
 	}
+
+	if s.mode&ParseLinePragmas != 0 {
+		s.interpretLineComment(s.text(offs, s.offset))
+	}
+	if s.mode&RetainCommentText == 0 || s.noLit {
+		return ""
+	}
+	lit := s.text(offs, s.litEnd(offs))
+	if hasCR && s.mode&StripCommentCR != 0 {
+		lit = StripCR(lit)
+	}
+	return string(lit)
+}
+
+var (
+	linePrefix      = []byte("//line ")
+	blockLinePrefix = []byte("/*line ")
+)
+
+// interpretLineComment registers the position information of a //line
+// or /*line*/ comment text with s.file. The scanner must be positioned
+// immediately after the comment.
+//
+func (s *Scanner) interpretLineComment(text []byte) {
+	var offs int
+	nextLine := false
+	switch {
+	case bytes.HasPrefix(text, linePrefix):
+		// The information applies to the line following the comment.
+		text = text[len(linePrefix):]
+		offs = s.offset + 1
+		nextLine = true
+	case bytes.HasPrefix(text, blockLinePrefix) && bytes.HasSuffix(text, []byte("*/")):
+		// The information applies from the end of the comment.
+		text = text[len(blockLinePrefix) : len(text)-2]
+		offs = s.offset
+	default:
+		return
+	}
+	text = bytes.TrimSpace(text)
+	// Get filename and line number, if any.
+	if i := bytes.LastIndex(text, []byte{':'}); i > 0 {
+		if line, err := strconv.Atoi(string(text[i+1:])); err == nil && line > 0 {
+			// Valid //line filename:line comment.
+			filename := string(bytes.TrimSpace(text[:i]))
+			if filename != "" {
+				filename = filepath.Clean(filename)
+				if !filepath.IsAbs(filename) {
+					// Make filename relative to current directory.
+					filename = filepath.Join(s.dir, filename)
+				}
+			}
+			if nextLine && s.ch == '\r' && s.peek() == '\n' {
+				offs++ // "\r\n" line ending (CRLineEndings mode only)
+			}
+			// A reading scanner may not have read the byte at offs yet.
+			for s.rd != nil && offs-s.base >= len(s.src) {
+				s.read()
+			}
+			s.file.AddLineInfo(offs, filename, line)
+		}
+	}
+}
+
+// literal returns the source text from offs to the current offset as
+// a string, truncated as by litEnd; or "" while scanning for ScanNoLit,
+// which avoids the copy.
+//
+func (s *Scanner) literal(offs int) string {
+	if s.noLit {
+		return ""
+	}
+	return string(s.text(offs, s.litEnd(offs)))
+}
+
+// tooLong reports whether the token from offs to the current offset
+// is longer than s.MaxLiteralLen.
+//
+func (s *Scanner) tooLong(offs int) bool {
+	return s.MaxLiteralLen > 0 && s.offset-offs > s.MaxLiteralLen
+}
+
+// litEnd returns the end offset of the literal of the token starting
+// at offs: the current offset, or, if the token is too long, the offset
+// of the last character boundary at most s.MaxLiteralLen bytes after
+// offs.
+//
+func (s *Scanner) litEnd(offs int) int {
+	if !s.tooLong(offs) {
+		return s.offset
+	}
+	end := offs + s.MaxLiteralLen
+	for end > offs && !utf8.RuneStart(s.src[end-s.base]) {
+		end--
+	}
+	return end
+}
+
+// StripCR returns a copy of b with all carriage returns ('\r') removed,
+// which turns "\r\n" line endings into "\n". If b contains no carriage
+// return, StripCR returns b.
+//
+func StripCR(b []byte) []byte {
+	i := 0
+	for i < len(b) && b[i] != '\r' {
+		i++
+	}
+	if i == len(b) {
+		return b
+	}
+	c := make([]byte, i, len(b)-1)
+	copy(c, b)
+	for _, ch := range b[i+1:] {
+		if ch != '\r' {
+			c = append(c, ch)
+		}
+	}
+	return c
 }
 
 func isLetter(ch rune) bool {
@@ -148,12 +969,90 @@ func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9' || ch >= utf8.RuneSelf && unicode.IsDigit(ch)
 }
 
+func isMark(ch rune) bool {
+	return ch >= utf8.RuneSelf && unicode.IsMark(ch)
+}
+
+// IsIdentStart reports whether ch may start an identifier: a Unicode
+// letter or '_'.
+//
+func IsIdentStart(ch rune) bool { return isLetter(ch) }
+
+// IsIdentContinue reports whether ch may follow the first character
+// of an identifier: a Unicode letter or digit, or '_'. Combining marks
+// continue an identifier as well in the NormalizeIdentifiers and
+// WarnNonNFC modes.
+//
+func IsIdentContinue(ch rune) bool { return isLetter(ch) || isDigit(ch) }
+
+func isASCIILetterOrDigit(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || b == '_' || '0' <= b && b <= '9'
+}
+
+// skipTo advances the scanner to the character at the file offset offs
+// of a source that is not read incrementally, given that the bytes from
+// the current character up to offs are ASCII characters other than NUL
+// and line endings: the state is as if next had been called for each.
+//
+func (s *Scanner) skipTo(offs int) {
+	if offs > s.rdOffset {
+		s.ch = rune(s.src[offs-1-s.base])
+		s.offset = offs - 1
+		s.rdOffset = offs
+	}
+	s.next()
+}
+
 func (s *Scanner) scanIdentifier() string {
 	offs := s.offset
-	for isLetter(s.ch) || isDigit(s.ch) {
+	if s.ch < utf8.RuneSelf && s.rd == nil {
+		// Fast path for ASCII: skip letters and digits without decoding.
+		i := s.rdOffset - s.base
+		for i < len(s.src) && isASCIILetterOrDigit(s.src[i]) {
+			i++
+		}
+		s.skipTo(s.base + i)
+	}
+	normalize := s.mode&NormalizeIdentifiers != 0
+	marks := s.mode&(NormalizeIdentifiers|WarnNonNFC) != 0
+	ascii := s.mode&ASCIIIdentsOnly != 0
+	for isLetter(s.ch) || isDigit(s.ch) || marks && isMark(s.ch) {
+		if ascii && s.ch >= utf8.RuneSelf {
+			s.error(s.offset, fmt.Sprintf("non-ASCII character %U in identifier", s.ch))
+			ascii = false // report the first one only
+		}
 		s.next()
 	}
-	return string(s.src[offs:s.offset])
+	text := s.text(offs, s.litEnd(offs))
+	if s.mode&WarnNonNFC != 0 && !norm.NFC.IsNormal(text) {
+		s.warn(offs, "identifier not in Unicode normal form NFC")
+	}
+	if s.mode&WarnConfusables != 0 {
+		if list := CheckConfusables(string(text)); len(list) > 0 {
+			i := bytes.IndexRune(text, list[0])
+			msg := fmt.Sprintf("identifier contains mixed-script confusable character %U (looks like %q)", list[0], confusables[list[0]])
+			s.warn(offs+i, msg)
+		}
+	}
+	if s.noLit {
+		return ""
+	}
+	if normalize && !norm.NFC.IsNormal(text) {
+		text = norm.NFC.Bytes(text)
+	}
+	if s.mode&InternIdentifiers == 0 {
+		return string(text)
+	}
+	// The conversion in the map index expression does not allocate.
+	if lit, ok := s.intern[string(text)]; ok {
+		return lit
+	}
+	if s.intern == nil {
+		s.intern = make(map[string]string)
+	}
+	lit := string(text)
+	s.intern[lit] = lit
+	return lit
 }
 
 func digitVal(ch rune) int {
@@ -168,7 +1067,27 @@ func digitVal(ch rune) int {
 	return 16 // larger than any legal digit val
 }
 
+// isImagSuffix reports whether the current character is the suffix 'i'
+// of an imaginary number. The 'i' must end the number; in "3inch" it
+// starts an identifier.
+//
+func (s *Scanner) isImagSuffix() bool {
+	if s.ch != 'i' {
+		return false
+	}
+	ch := s.peekRune()
+	return !isLetter(ch) && !isDigit(ch)
+}
+
 func (s *Scanner) scanMantissa(base int) {
+	if s.rd == nil && digitVal(s.ch) < base {
+		// Fast path: digits are ASCII.
+		i := s.rdOffset - s.base
+		for i < len(s.src) && digitVal(rune(s.src[i])) < base {
+			i++
+		}
+		s.skipTo(s.base + i)
+	}
 	for digitVal(s.ch) < base {
 		s.next()
 	}
@@ -178,6 +1097,7 @@ func (s *Scanner) scanNumber(seenDecimalPoint bool) (token.Token, string) {
 	// digitVal(s.ch) < 10.
 	offs := s.offset
 	tok := token.INT
+	errs := s.ErrorCount
 
 	if seenDecimalPoint {
 		offs--
@@ -198,6 +1118,15 @@ func (s *Scanner) scanNumber(seenDecimalPoint bool) (token.Token, string) {
 				// Only scanned "0x" or "0X".
 				s.error(offs, "illegal hexadecimal number")
 			}
+		} else if s.mode&OctalPrefix != 0 && (s.ch == 'o' || s.ch == 'O') {
+			// Octal int with prefix.
+			s.next()
+			s.scanMantissa(8)
+			if s.offset-offs <= 2 || s.ch == '8' || s.ch == '9' {
+				// Only scanned "0o" or "0O", or an illegal digit follows.
+				s.scanMantissa(10)
+				s.error(offs, "illegal octal number")
+			}
 		} else {
 			// Octal int or float.
 			seenDecimalPoint := false
@@ -207,12 +1136,14 @@ func (s *Scanner) scanNumber(seenDecimalPoint bool) (token.Token, string) {
 				seenDecimalPoint = true
 				s.scanMantissa(10)
 			}
-			if s.ch == '.' || s.ch == 'e' || s.ch == 'E' {
+			if s.ch == '.' || s.ch == 'e' || s.ch == 'E' || s.isImagSuffix() {
 				goto fraction
 			}
 			// Octal int.
 			if seenDecimalPoint {
 				s.error(offs, "illegal octal number")
+			} else if s.mode&OctalPrefix != 0 && s.offset-offs > 1 {
+				s.warn(offs, "leading zero in decimal literal; use 0o prefix for octal")
 			}
 		}
 		goto exit
@@ -231,21 +1162,39 @@ fraction:
 exponent:
 	if s.ch == 'e' || s.ch == 'E' {
 		tok = token.FLOAT
+		offs := s.offset
 		s.next()
 		if s.ch == '-' || s.ch == '+' {
 			s.next()
 		}
+		if digitVal(s.ch) >= 10 {
+			s.error(offs, "exponent has no digits")
+		}
 		s.scanMantissa(10)
 	}
 
+	if s.isImagSuffix() {
+		tok = token.IMAG
+		s.next()
+	}
+
 exit:
-	return tok, string(s.src[offs:s.offset])
+	// The literal ends here, suffix included; a letter right after it
+	// is most likely a typo (e.g. "0x1G"). The offending identifier is
+	// scanned as the next token. Don't report it if the literal itself
+	// is malformed already (e.g. "1e-x").
+	if isLetter(s.ch) && s.ErrorCount == errs {
+		s.error(s.offset, "identifier immediately after numeric literal")
+	}
+	return tok, s.literal(offs)
 }
 
 // scanEscape parses an escape sequence where rune is the accepted
-// escaped quote. In case of a syntax error, it stops at the offending
-// character (without consuming it) and returns false. Otherwise
-// it returns true.
+// escaped quote. In double-quoted strings, \$ is accepted as well: it
+// denotes a literal '$' that does not start an interpolation, like $$
+// (which needs no escape handling). In case of a syntax error, it stops
+// at the offending character (without consuming it) and returns false.
+// Otherwise it returns true.
 //
 func (s *Scanner) scanEscape(quote rune) bool {
 	offs := s.offset
@@ -267,7 +1216,18 @@ func (s *Scanner) scanEscape(quote rune) bool {
 	case 'U':
 		s.next()
 		n, base, max = 8, 16, unicode.MaxRune
+	case '$':
+		if quote == '"' {
+			s.next()
+			return true
+		}
+		fallthrough
 	default:
+		if s.mode&LenientEscapes != 0 && s.ch >= 0 && !s.isLineEnd(s.ch) {
+			s.warn(offs, "unknown escape sequence")
+			s.next()
+			return true
+		}
 		msg := "unknown escape sequence"
 		if s.ch < 0 {
 			msg = "escape sequence not terminated"
@@ -300,44 +1260,180 @@ func (s *Scanner) scanEscape(quote rune) bool {
 	return true
 }
 
-func (s *Scanner) scanString(quote rune) string {
+// scanString scans a string literal and returns it together with the
+// number of characters it contains, where an escape sequence counts as
+// one character. If the literal is not terminated or contains an invalid
+// escape sequence, the count is -1.
+//
+func (s *Scanner) scanString(quote rune) (string, int) {
 	// Quote opening already consumed.
 	offs := s.offset - 1
+	n := 0
 
 	for {
 		ch := s.ch
-		if ch == '\n' || ch < 0 {
+		if s.isLineEnd(ch) || ch < 0 {
 			s.error(offs, "string literal not terminated")
+			n = -1
 			break
 		}
 		s.next()
 		if ch == quote {
 			break
 		}
-		if ch == '\\' {
-			s.scanEscape(quote)
+		if ch == '\\' && !s.scanEscape(quote) {
+			n = -1
+		}
+		if n >= 0 {
+			n++
+		}
+	}
+
+	return s.literal(offs), n
+}
+
+func (s *Scanner) scanRawString() string {
+	// 'r' prefix not yet consumed; s.peek() is the opening quote.
+	offs := s.offset
+	s.next()
+	quote := s.ch
+	s.next()
+
+	for {
+		ch := s.ch
+		if s.isLineEnd(ch) || ch < 0 {
+			s.error(offs+1, "string literal not terminated")
+			break
+		}
+		s.next()
+		if ch == quote {
+			break
 		}
 	}
 
-	return string(s.src[offs:s.offset])
+	return s.literal(offs)
+}
+
+// IsWhitespace reports whether ch is white space separating tokens:
+// a space, tab, newline, or carriage return.
+//
+func IsWhitespace(ch rune) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+// SkipWhitespace returns the offset of the first byte at or after
+// offset in src that is not white space as defined by IsWhitespace,
+// or len(src) if there is none. It panics if offset is not within
+// src.
+//
+func SkipWhitespace(src []byte, offset int) int {
+	if offset < 0 || offset > len(src) {
+		panic(fmt.Sprintf("offset %d out of range", offset))
+	}
+	for offset < len(src) && IsWhitespace(rune(src[offset])) {
+		offset++
+	}
+	return offset
 }
 
 func (s *Scanner) skipWhiteSpace() {
-	for s.ch == ' ' || s.ch == '\t' || s.ch == '\n' || s.ch == '\r' {
+	// Track the leading white space of lines (WarnMixedIndent mode only).
+	lead := s.mode&WarnMixedIndent != 0 && s.offset == s.lineOffset
+	tabs, spaces := false, false
+	for IsWhitespace(s.ch) || s.ch == bom && s.mode&LenientBOM != 0 {
+		switch s.ch {
+		case '\r':
+			if s.mode&CRLineEndings == 0 {
+				break
+			}
+			fallthrough
+		case '\n':
+			s.lineStart = true
+			s.lineOffset = s.offset + 1
+			lead = s.mode&WarnMixedIndent != 0
+			tabs, spaces = false, false
+		case '\t':
+			tabs = tabs || lead
+		case ' ':
+			spaces = spaces || lead
+		}
 		s.next()
 	}
+	if tabs && spaces && s.ch >= 0 {
+		s.warn(s.lineOffset, "mixed tabs and spaces in indentation")
+	}
+}
+
+// scanIndent compares the indentation of the current line with the
+// indentation of the enclosing blocks and returns the resulting INDENT
+// or DEDENT token, if any. It is called with s.lineStart set and the
+// scanner positioned at the first character after the leading white
+// space. After an INDENT or DEDENT, s.lineStart stays set until the
+// indentation of the line is fully accounted for.
+//
+func (s *Scanner) scanIndent() (tok token.Token, ok bool) {
+	if s.ch == '/' && (s.peek() == '/' || s.peek() == '*') {
+		// Comments don't affect indentation.
+		return
+	}
+
+	indent := ""
+	if s.ch >= 0 {
+		indent = string(s.text(s.lineOffset, s.offset))
+		if s.mode&LenientBOM != 0 {
+			indent = strings.Replace(indent, "\ufeff", "", -1)
+		}
+		if strings.TrimFunc(indent, IsWhitespace) != "" {
+			// A comment precedes the token on its line.
+			s.lineStart = false
+			return
+		}
+	}
+	n := len(s.indents)
+	top := s.indents[n-1]
+
+	switch {
+	case indent == top:
+		s.lineStart = false
+	case strings.HasPrefix(indent, top):
+		s.indents = append(s.indents, indent)
+		s.lineStart = false
+		tok, ok = token.INDENT, true
+	case strings.HasPrefix(top, indent):
+		// The line closes the innermost block. If it is indented
+		// deeper than the next outer block, it matches no level.
+		if len(indent) > len(s.indents[n-2]) {
+			s.error(s.offset, "unindent does not match any outer indentation level")
+			s.lineStart = false
+		}
+		s.indents = s.indents[:n-1]
+		tok, ok = token.DEDENT, true
+	default:
+		// Neither indentation extends the other; keep the current level.
+		s.error(s.offset, "inconsistent use of tabs and spaces in indentation")
+		s.lineStart = false
+	}
+	return
 }
 
 // Scan scans the next token and returns the token position, the token,
 // and its literal string if applicable. The source end is indicated by
 // token.EOF.
 //
-// If the returned token is literal (token.IDENT, token.BOOL, token.INT,
-// token.FLOAT, token.STRING) or token.COMMENT, the literal string has
-// the corresponding value.
+// If the returned token is literal (token.IDENT, token.BOOL, token.NULL,
+// token.BLANK, token.INT, token.FLOAT, token.IMAG, token.CHAR,
+// token.STRING, token.RAWSTRING), the literal string has the corresponding
+// value. A quoted literal '...' is a token.CHAR if it contains exactly one
+// character or valid escape sequence, and a token.RAWSTRING otherwise. If
+// the returned token is token.COMMENT and the RetainCommentText mode bit
+// is set, the literal string is the comment text including the comment
+// delimiters but excluding the newline that ends a //-style comment;
+// otherwise it is empty. If the StripCommentCR mode bit is set as well,
+// the text has all carriage returns removed.
 //
 // If the returned token is token.ILLEGAL, the literal string is the
-// offending character.
+// offending character, or the offending source bytes for a run of bytes
+// that are not valid UTF-8; such a run is reported as a single error.
 //
 // In all other cases, Scan returns an empty literal string.
 //
@@ -348,23 +1444,554 @@ func (s *Scanner) skipWhiteSpace() {
 // must check the scanner's ErrorCount or the number of calls
 // of the error handler, if there was one installed.
 //
+// If MaxErrors is > 0, the error handler is called once more after the
+// first MaxErrors errors, with the message "too many errors" at the
+// position of the last one; this final diagnostic does not count in
+// ErrorCount. Any further errors are dropped, and once that many errors
+// were encountered Scan returns token.EOF without scanning the
+// remaining source. A MaxErrors value of 0 means no limit.
+//
+// If MaxLiteralLen is > 0, a token longer than MaxLiteralLen bytes is
+// reported as error "token too long" at its position, and its literal
+// string is truncated to at most MaxLiteralLen bytes, at a character
+// boundary; the token is scanned to its end as usual. A MaxLiteralLen
+// value of 0 means no limit.
+//
+// If Progress is not nil, Scan calls it with the offset reached and the
+// size of the file whenever it advanced at least ProgressInterval bytes
+// (1 MiB if ProgressInterval is 0) since the previous call, and once
+// more upon reaching EOF. For a scanner initialized with InitReader, the
+// size is that of the source read so far.
+//
 // Scan adds line information to the file added to the file
 // set with Init. Token positions are relative to that file
 // and thus relative to the file set.
 //
 func (s *Scanner) Scan() (pos token.Pos, tok token.Token, lit string) {
+	if s.peeked {
+		s.peeked = false
+		s.adjacent = s.peekAdj
+		s.trivia = s.peekTrv
+		return s.peekPos, s.peekTok, s.peekLit
+	}
+	offs := s.offset
+	pos, tok, lit = s.scan()
+	s.trivia = s.leading(offs, pos)
+	s.adjacent = s.follow(tok, s.trivia)
+	return
+}
+
+// leading returns the source text from offs to the token position pos,
+// which is the leading trivia of the token in RetainTrivia mode.
+//
+func (s *Scanner) leading(offs int, pos token.Pos) string {
+	if s.mode&RetainTrivia == 0 {
+		return ""
+	}
+	return string(s.text(offs, s.file.Offset(pos)))
+}
+
+// LeadingTrivia returns the white space and comments that the scanner
+// skipped before the token most recently returned by Scan, ScanNoLit,
+// or ScanExtended, if the RetainTrivia mode bit is set; for token.EOF,
+// that is the trailing trivia of the source. Each token takes up the
+// source from its position to its end as reported by ScanExtended, so
+// that the trivia and the text of all tokens up to and including EOF
+// concatenate to the source, except for a byte order mark at its
+// beginning. Without RetainTrivia, LeadingTrivia returns "".
+//
+func (s *Scanner) LeadingTrivia() string {
+	return s.trivia
+}
+
+// ScanExtended is like Scan but also returns the end position of the
+// token, immediately after its last character. The extent of a token
+// from pos to end covers the token's source text, which may differ
+// from its literal string. A //-style comment ends before the newline;
+// EOF, INDENT, and DEDENT tokens are empty and end where they start.
+//
+func (s *Scanner) ScanExtended() (pos token.Pos, tok token.Token, lit string, end token.Pos) {
+	peeked, end := s.peeked, s.peekEnd
+	pos, tok, lit = s.Scan()
+	if !peeked {
+		// The scanner stops immediately after the token.
+		end = s.file.Pos(s.offset)
+	}
+	return
+}
+
+// follow records tok as the most recently scanned token and reports
+// whether it is a string literal immediately following another one.
+// The trivia, if retained, must not contain a comment ('/').
+//
+func (s *Scanner) follow(tok token.Token, trivia string) bool {
+	adj := tok == token.STRING && s.prevTok == token.STRING && strings.IndexByte(trivia, '/') < 0
+	s.prevTok = tok
+	return adj
+}
+
+// AdjacentToPrevious reports whether the token most recently returned
+// by Scan is a token.STRING that immediately follows another STRING,
+// with only white space between them. A comment, even if skipped as
+// trivia, or, with ScanIndent, an INDENT or DEDENT token in between
+// separates them. Parsers can use this to implement implicit string
+// concatenation.
+//
+func (s *Scanner) AdjacentToPrevious() bool {
+	return s.adjacent
+}
+
+// PeekToken returns the token that the next call of Scan will return,
+// with its position and literal string, without consuming it. The token
+// is scanned only once: errors encountered while scanning it are reported
+// by the first call of PeekToken, and neither further calls of PeekToken
+// nor the following Scan report them again. While a token is pending,
+// Peek returns the character following that token.
+//
+func (s *Scanner) PeekToken() (pos token.Pos, tok token.Token, lit string) {
+	if !s.peeked {
+		offs := s.offset
+		s.peekOff = offs
+		s.peekPos, s.peekTok, s.peekLit = s.scan()
+		s.peekTrv = s.leading(offs, s.peekPos)
+		s.peekAdj = s.follow(s.peekTok, s.peekTrv)
+		s.peekErrs = s.tokErrs
+		s.peekEnd = s.file.Pos(s.offset)
+		s.peeked = true
+	}
+	return s.peekPos, s.peekTok, s.peekLit
+}
+
+// ScanNoLit is like Scan but does not return the literal string of the
+// token, which saves allocating it: except for illegal non-ASCII
+// characters, ScanNoLit allocates nothing beyond the line table of the
+// file, while Scan allocates a string for each literal token. If the
+// token was scanned ahead by PeekToken, its literal is discarded.
+//
+func (s *Scanner) ScanNoLit() (token.Pos, token.Token) {
+	if s.peeked {
+		s.peeked = false
+		s.adjacent = s.peekAdj
+		s.trivia = s.peekTrv
+		return s.peekPos, s.peekTok
+	}
+	offs := s.offset
+	s.noLit = true
+	pos, tok, _ := s.scan()
+	s.noLit = false
+	s.trivia = s.leading(offs, pos)
+	s.adjacent = s.follow(tok, s.trivia)
+	return pos, tok
+}
+
+// SkipTo scans tokens until one of toks or token.EOF is scanned, and
+// returns that token like Scan does. The tokens skipped are scanned as
+// usual: errors are reported and counted in ErrorCount. A parser can use
+// SkipTo to recover from a syntax error at a synchronization point such
+// as the next closing brace.
+//
+func (s *Scanner) SkipTo(toks ...token.Token) (pos token.Pos, tok token.Token, lit string) {
+	for {
+		pos, tok, lit = s.Scan()
+		if tok == token.EOF {
+			return
+		}
+		for _, t := range toks {
+			if tok == t {
+				return
+			}
+		}
+	}
+}
+
+// SkipToLineEnd skips the tokens starting on the current line, the line
+// of the end of the most recently scanned token, so that the next token
+// scanned is the first one on a following line. A comment starting on
+// the current line is skipped as a whole even if it extends over several
+// lines. The tokens skipped are scanned as by SkipTo. Lines are those of
+// the source, regardless of //line comments.
+//
+func (s *Scanner) SkipToLineEnd() {
+	offs := s.offset
+	if s.peeked {
+		offs = s.peekOff
+	}
+	line := s.file.PositionFor(s.file.Pos(offs), false).Line
+	for {
+		pos, tok, _ := s.PeekToken()
+		if tok == token.EOF || s.file.PositionFor(pos, false).Line > line {
+			return
+		}
+		s.ScanNoLit()
+	}
+}
+
+// SkipBalanced skips tokens up to and including the close token matching
+// an open token already scanned, and returns the position of the close
+// token: open and close tokens in between are counted, so that nested
+// pairs are skipped as a whole. Brackets within comments and literals
+// are not tokens and thus not counted. If the source ends before the
+// matching close token, SkipBalanced returns the position of token.EOF.
+// The tokens skipped are scanned as by SkipTo.
+//
+func (s *Scanner) SkipBalanced(open, close token.Token) token.Pos {
+	depth := 1
+	for {
+		pos, tok := s.ScanNoLit()
+		switch tok {
+		case token.EOF:
+			return pos
+		case open:
+			depth++
+		case close:
+			if depth--; depth == 0 {
+				return pos
+			}
+		}
+	}
+}
+
+// ScanBytes is like Scan but returns the literal of the token as a byte
+// slice aliasing the source instead of a string, which saves allocating
+// it. The slice must not be modified. For a scanner initialized with
+// Init, it remains valid as long as the src passed to Init; for a
+// scanner initialized with InitReader, it is valid only until the next
+// call of a Scan method. The literal is the one Scan returns; it is a
+// copy for a comment with carriage returns removed (StripCommentCR mode)
+// and for an identifier that is not in normalization form NFC
+// (NormalizeIdentifiers mode).
+//
+func (s *Scanner) ScanBytes() (pos token.Pos, tok token.Token, lit []byte) {
+	peeked, end := s.peeked, s.peekEnd
+	pos, tok = s.ScanNoLit()
+	if !peeked {
+		end = s.file.Pos(s.offset)
+	}
+	switch {
+	case tok.IsLiteral(), tok == token.ILLEGAL:
+		lit = s.text(s.file.Offset(pos), s.file.Offset(end))
+		if tok == token.IDENT && s.mode&NormalizeIdentifiers != 0 && !norm.NFC.IsNormal(lit) {
+			lit = norm.NFC.Bytes(lit)
+		}
+	case tok == token.COMMENT && s.mode&RetainCommentText != 0:
+		lit = s.text(s.file.Offset(pos), s.file.Offset(end))
+		if s.mode&StripCommentCR != 0 {
+			lit = StripCR(lit)
+		}
+	}
+	return
+}
+
+// A Checkpoint records the scanning state of a Scanner, for restoring
+// it later via Restore.
+//
+type Checkpoint struct {
+	file       *token.File
+	ch         rune
+	offset     int
+	rdOffset   int
+	indents    []string
+	lineStart  bool
+	lineOffset int
+	peeked     bool
+	peekPos    token.Pos
+	peekTok    token.Token
+	peekLit    string
+	peekAdj    bool
+	peekEnd    token.Pos
+	peekTrv    string
+	peekOff    int
+	peekErrs   ErrorList
+	tokErrs    ErrorList
+	errs       ErrorList
+	trivia     string
+	prevTok    token.Token
+	adjacent   bool
+	errorCount int
+	warnCount  int
+}
+
+// Checkpoint returns the current scanning state of s, including its
+// ErrorCount. Checkpoints are invalidated by Init and InitReader.
+//
+func (s *Scanner) Checkpoint() Checkpoint {
+	cp := Checkpoint{
+		file:       s.file,
+		ch:         s.ch,
+		offset:     s.offset,
+		rdOffset:   s.rdOffset,
+		lineStart:  s.lineStart,
+		lineOffset: s.lineOffset,
+		peeked:     s.peeked,
+		peekPos:    s.peekPos,
+		peekTok:    s.peekTok,
+		peekLit:    s.peekLit,
+		peekAdj:    s.peekAdj,
+		peekEnd:    s.peekEnd,
+		peekTrv:    s.peekTrv,
+		peekOff:    s.peekOff,
+		peekErrs:   s.peekErrs,
+		tokErrs:    s.tokErrs,
+		errs:       s.errs,
+		trivia:     s.trivia,
+		prevTok:    s.prevTok,
+		adjacent:   s.adjacent,
+		errorCount: s.ErrorCount,
+		warnCount:  s.WarningCount,
+	}
+	if s.mode&ScanIndent != 0 {
+		// Scanning modifies the indentation stack in place.
+		cp.indents = append([]string(nil), s.indents...)
+	}
+	return cp
+}
+
+// Restore rewinds s to the scanning state recorded by cp, so that the
+// tokens following the checkpoint are scanned again. ErrorCount and
+// WarningCount are reset to their values at the checkpoint; errors and
+// warnings already reported are reported again when scanned again.
+// Lines and //line information added to the file after the checkpoint
+// are kept, since adding them again has no effect.
+//
+// A scanner initialized with InitReader discards source text it no
+// longer needs; its checkpoints can be restored only until the second
+// token after the checkpoint is scanned. Restore panics if cp was not
+// taken since the last initialization of s or cannot be restored.
+//
+func (s *Scanner) Restore(cp Checkpoint) {
+	if cp.file != s.file || cp.offset < s.base || cp.lineStart && cp.lineOffset < s.base {
+		panic("invalid scanner checkpoint")
+	}
+	s.ch = cp.ch
+	s.offset = cp.offset
+	s.rdOffset = cp.rdOffset
+	if s.mode&ScanIndent != 0 {
+		s.indents = append(s.indents[:0], cp.indents...)
+	}
+	s.lineStart = cp.lineStart
+	s.lineOffset = cp.lineOffset
+	s.peeked = cp.peeked
+	s.peekPos = cp.peekPos
+	s.peekTok = cp.peekTok
+	s.peekLit = cp.peekLit
+	s.peekAdj = cp.peekAdj
+	s.peekEnd = cp.peekEnd
+	s.peekTrv = cp.peekTrv
+	s.peekOff = cp.peekOff
+	s.peekErrs = cp.peekErrs
+	s.tokErrs = cp.tokErrs
+	s.trivia = cp.trivia
+	s.prevTok = cp.prevTok
+	s.adjacent = cp.adjacent
+	s.ErrorCount = cp.errorCount
+	s.errs = cp.errs
+	s.WarningCount = cp.warnCount
+}
+
+// Seek repositions s at the file offset offset; scanning resumes there.
+// If offset is a token boundary of a full scan, the following tokens are
+// those of the full scan. Seek discards a peeked token and keeps
+// ErrorCount, WarningCount, and the indentation of enclosing blocks.
+// Lines skipped by seeking forward are added to the file as if they
+// were scanned. Seek panics if offset is not within the source or is
+// inside a UTF-8 encoded character. A scanner initialized with
+// InitReader can only seek within the text it still holds: from the
+// start of the current token to the end of the text read so far.
+//
+func (s *Scanner) Seek(offset int) {
+	if offset < s.base || offset > s.base+len(s.src) {
+		panic(fmt.Sprintf("seek offset %d out of range", offset))
+	}
+	// Bytes of an illegal encoding are characters of their own.
+	for i := offset - s.base - 1; i >= 0 && i > offset-s.base-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s.src[i]) {
+			if _, w := utf8.DecodeRune(s.src[i:]); i+w > offset-s.base {
+				panic(fmt.Sprintf("seek offset %d inside a character", offset))
+			}
+			break
+		}
+	}
+	// Add the lines skipped when seeking forward, so that positions
+	// after them are correct.
+	for i := s.offset; i < offset; i++ {
+		switch s.src[i-s.base] {
+		case '\n':
+			s.addLine(i + 1)
+		case '\r':
+			if s.mode&CRLineEndings != 0 && (i+1-s.base == len(s.src) || s.src[i+1-s.base] != '\n') {
+				s.addLine(i + 1)
+			}
+		}
+	}
+	s.ch = ' ' // don't add a line at offset
+	s.rdOffset = offset
+	s.next()
+	s.skipPrelude()
+	s.keep = offset
+	s.lineStart = offset == 0 || offset > s.base && s.isLineEnd(rune(s.src[offset-1-s.base]))
+	if s.lineStart {
+		s.lineOffset = offset
+	}
+	s.peeked = false
+	s.prevTok = token.ILLEGAL
+	s.adjacent = false
+	s.trivia = ""
+}
+
+// ScanErr is like Scan but also returns the errors reported while
+// scanning the token, or nil if there were none: an *Error for a single
+// error, and an ErrorList otherwise. Errors are reported to the error
+// handler, if any, and counted in ErrorCount as usual. Errors of the
+// first character of the source, reported by Init, belong to the first
+// token.
+//
+func (s *Scanner) ScanErr() (pos token.Pos, tok token.Token, lit string, err error) {
+	peeked := s.peeked
+	pos, tok, lit = s.Scan()
+	list := s.tokErrs
+	if peeked {
+		list = s.peekErrs
+	}
+	switch len(list) {
+	case 0:
+		return pos, tok, lit, nil
+	case 1:
+		return pos, tok, lit, list[0]
+	}
+	return pos, tok, lit, list
+}
+
+// SafeScan is like Scan but recovers from a panic during scanning, for
+// instance due to a corrupted scanner state or a panicking error handler,
+// and returns it as an error together with token.ILLEGAL and no position.
+// After such an error, the scanner state is undefined and s must be
+// initialized again before further use.
+//
+func (s *Scanner) SafeScan() (pos token.Pos, tok token.Token, lit string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pos, tok, lit = token.NoPos, token.ILLEGAL, ""
+			err = fmt.Errorf("scanner: internal error: %v", r)
+		}
+	}()
+	pos, tok, lit = s.Scan()
+	return
+}
+
+// ctxCheckInterval is the number of ScanContext calls
+// between checks of the context for cancellation.
+//
+const ctxCheckInterval = 1024
+
+// ScanContext is like Scan but checks every so many calls (starting with
+// the first one after Init) whether ctx is done. If so, it returns
+// token.EOF and the context's error without scanning any further.
+//
+func (s *Scanner) ScanContext(ctx context.Context) (token.Pos, token.Token, string, error) {
+	if s.ctxCount%ctxCheckInterval == 0 {
+		if err := ctx.Err(); err != nil {
+			return token.NoPos, token.EOF, "", err
+		}
+	}
+	s.ctxCount++
+	pos, tok, lit := s.Scan()
+	return pos, tok, lit, nil
+}
+
+// scan scans the next token and records the errors reported since the
+// previous token, including those reported by Init for the first one,
+// in s.tokErrs.
+//
+func (s *Scanner) scan() (pos token.Pos, tok token.Token, lit string) {
+	pos, tok, lit = s.scanToken()
+	s.tokErrs, s.errs = s.errs, nil
+	if s.Progress != nil {
+		s.reportProgress(tok)
+	}
+	return
+}
+
+// defaultProgressInterval is the number of bytes between Progress
+// calls if ProgressInterval is not set.
+//
+const defaultProgressInterval = 1 << 20
+
+// reportProgress calls s.Progress if the scanner advanced at least
+// ProgressInterval bytes since the last call, or once if tok is EOF.
+// For a reading scanner, the total is the size of the source read so
+// far.
+//
+func (s *Scanner) reportProgress(tok token.Token) {
+	if s.progress < 0 {
+		return // EOF reported already
+	}
+	total := s.file.Size()
+	if tok == token.EOF {
+		s.progress = -1
+		s.Progress(s.offset, total)
+		return
+	}
+	interval := s.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	if s.offset-s.progress >= interval {
+		s.progress = s.offset
+		s.Progress(s.offset, total)
+	}
+}
+
+func (s *Scanner) scanToken() (pos token.Pos, tok token.Token, lit string) {
+	if s.tooManyErrors() {
+		return s.file.Pos(s.offset), token.EOF, ""
+	}
+
+	// A reading scanner needs the source from the token start on,
+	// and from the line start on for the indentation.
+	s.keep = s.offset
+	if s.lineStart && s.lineOffset < s.keep {
+		s.keep = s.lineOffset
+	}
+
 	s.skipWhiteSpace()
+	if s.mode&RetainTrivia != 0 {
+		for s.ch == '/' && (s.peek() == '/' || s.peek() == '*') {
+			s.next()
+			s.scanComment()
+			s.skipWhiteSpace()
+		}
+	}
 
 	// Current token start.
 	pos = s.file.Pos(s.offset)
 
+	if s.mode&ScanIndent != 0 && (s.lineStart || s.ch < 0) {
+		if tok, ok := s.scanIndent(); ok {
+			return pos, tok, ""
+		}
+	}
+
 	// Determine token value.
 	switch ch := s.ch; {
+	case ch == 'r' && (s.peek() == '"' || s.peek() == '\''):
+		tok = token.RAWSTRING
+		lit = s.scanRawString()
 	case isLetter(ch):
+		offs := s.offset
 		lit = s.scanIdentifier()
-		if lit == "true" || lit == "false" {
+		// The comparisons don't allocate, and work without literal.
+		null := "null"
+		if s.mode&NilKeyword != 0 {
+			null = "nil"
+		}
+		switch id := s.text(offs, s.offset); string(id) {
+		case "true", "false":
 			tok = token.BOOL
-		} else {
+		case null:
+			tok = token.NULL
+		case "_":
+			tok = token.BLANK
+		default:
 			tok = token.IDENT
 		}
 	case '0' <= ch && ch <= '9':
@@ -376,12 +2003,24 @@ func (s *Scanner) Scan() (pos token.Pos, tok token.Token, lit string) {
 			tok = token.EOF
 		case '"':
 			tok = token.STRING
-			lit = s.scanString('"')
+			lit, _ = s.scanString('"')
 		case '\'':
-			tok = token.RAWSTRING
-			lit = s.scanString('\'')
+			// A single character or escape sequence is a character
+			// literal; anything else is a raw string.
+			var n int
+			lit, n = s.scanString('\'')
+			if n == 1 {
+				tok = token.CHAR
+			} else {
+				tok = token.RAWSTRING
+			}
 		case ':':
-			tok = token.COLON
+			if s.ch == '=' {
+				s.next()
+				tok = token.DEFINE
+			} else {
+				tok = token.COLON
+			}
 		case '.':
 			if '0' <= s.ch && s.ch <= '9' {
 				tok, lit = s.scanNumber(true)
@@ -411,12 +2050,25 @@ func (s *Scanner) Scan() (pos token.Pos, tok token.Token, lit string) {
 		case '/':
 			if s.ch == '/' || s.ch == '*' {
 				tok = token.COMMENT
-				s.scanComment()
+				lit = s.scanComment()
 			} else {
 				tok = token.QUO
 			}
 		case '%':
 			tok = token.REM
+		case '^':
+			tok = token.XOR
+		case '#':
+			if s.mode&AllowSetLiterals != 0 && s.ch == '{' {
+				s.next()
+				tok = token.LBRACE_HASH
+			} else if s.mode&AllowHash != 0 {
+				tok = token.HASH
+			} else {
+				s.error(s.file.Offset(pos), fmt.Sprintf("illegal character %#U", ch))
+				tok = token.ILLEGAL
+				lit = string(ch)
+			}
 		case '<':
 			if s.ch == '=' {
 				s.next()
@@ -464,14 +2116,30 @@ func (s *Scanner) Scan() (pos token.Pos, tok token.Token, lit string) {
 				lit = string(ch)
 			}
 		default:
+			offs := s.file.Offset(pos)
+			tok = token.ILLEGAL
+			if ch == utf8.RuneError && s.offset-offs == 1 {
+				// A run of invalid bytes is a single token;
+				// next reports it - don't repeat.
+				for s.invalidByte() {
+					s.next()
+				}
+				lit = s.literal(offs)
+				break
+			}
 			// next reports unexpected BOMs - don't repeat.
 			if ch != bom {
-				s.error(s.file.Offset(pos), fmt.Sprintf("illegal character %#U", ch))
+				s.error(offs, fmt.Sprintf("illegal character %#U", ch))
 			}
-			tok = token.ILLEGAL
 			lit = string(ch)
 		}
 	}
+	if tok == token.RAWSTRING && s.mode&ForbidRawStrings != 0 {
+		s.error(s.file.Offset(pos), "raw strings not allowed here")
+	}
+	if s.tooLong(s.file.Offset(pos)) {
+		s.error(s.file.Offset(pos), "token too long")
+	}
 
 	return
 }
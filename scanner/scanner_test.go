@@ -5,7 +5,15 @@
 package scanner
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"unicode/utf8"
 
 	"github.com/vastri/zolang/token"
 )
@@ -48,6 +56,12 @@ var tokens = [...]elt{
 	{token.IDENT, "ŝfoo", literal},
 	{token.BOOL, "true", literal},
 	{token.BOOL, "false", literal},
+	{token.NULL, "null", literal},
+	{token.IDENT, "nullable", literal},
+	{token.IDENT, "nil", literal},
+	{token.BLANK, "_", literal},
+	{token.IDENT, "_x", literal},
+	{token.IDENT, "__", literal},
 	{token.INT, "0", literal},
 	{token.INT, "1", literal},
 	{token.INT, "123456789012345678890", literal},
@@ -60,16 +74,37 @@ var tokens = [...]elt{
 	{token.FLOAT, "1e+100", literal},
 	{token.FLOAT, "1e-100", literal},
 	{token.FLOAT, "2.71828e-1000", literal},
+	{token.IMAG, "0i", literal},
+	{token.IMAG, "2i", literal},
+	{token.IMAG, "0123i", literal},
+	{token.IMAG, "3.14i", literal},
+	{token.IMAG, ".5i", literal},
+	{token.IMAG, "1e-3i", literal},
+	{token.IMAG, "1e3i", literal},
+	{token.IMAG, "3.0i", literal},
+	{token.IDENT, "i", literal},
+	{token.CHAR, "'a'", literal},
+	{token.CHAR, "'ŝ'", literal},
+	{token.CHAR, "'\\n'", literal},
+	{token.CHAR, "'\\''", literal},
+	{token.CHAR, "'\\x41'", literal},
+	{token.CHAR, "'\\u0041'", literal},
+	{token.CHAR, "'\\U0001F600'", literal},
 	{token.STRING, "\"\"", literal},
 	{token.STRING, "\"a\"", literal},
 	{token.STRING, "\"foobar\"", literal},
 	{token.STRING, "\"${v}\"", literal},
 	{token.STRING, "\"foo${v}bar\"", literal},
 	{token.RAWSTRING, "''", literal},
-	{token.RAWSTRING, "'a'", literal},
+	{token.RAWSTRING, "'ab'", literal},
+	{token.RAWSTRING, "'\\n\\t'", literal},
 	{token.RAWSTRING, "'foobar'", literal},
 	{token.RAWSTRING, "'${v}'", literal},
 	{token.RAWSTRING, "'foo${v}bar'", literal},
+	{token.RAWSTRING, `r"C:\temp\new"`, literal},
+	{token.RAWSTRING, `r'\d+\.\d*'`, literal},
+	{token.RAWSTRING, `r""`, literal},
+	{token.IDENT, "r", literal},
 
 	// Operators and delimiters
 	{token.ADD, "+", operator},
@@ -80,6 +115,7 @@ var tokens = [...]elt{
 
 	{token.AND, "&&", operator},
 	{token.OR, "||", operator},
+	{token.XOR, "^", operator},
 
 	{token.EQL, "==", operator},
 	{token.LSS, "<", operator},
@@ -90,6 +126,7 @@ var tokens = [...]elt{
 	{token.NEQ, "!=", operator},
 	{token.LEQ, "<=", operator},
 	{token.GEQ, ">=", operator},
+	{token.DEFINE, ":=", operator},
 
 	{token.LPAREN, "(", operator},
 	{token.LBRACK, "[", operator},
@@ -165,7 +202,7 @@ func TestScan(t *testing.T) {
 
 	index := 0
 	for {
-		pos, tok, lit := s.Scan()
+		pos, tok, lit, end := s.ScanExtended()
 
 		// Check position.
 		if tok == token.EOF {
@@ -181,6 +218,16 @@ func TestScan(t *testing.T) {
 			e = tokens[index]
 			index++
 		}
+
+		// Check end position; a //-style comment excludes the newline.
+		text := e.lit
+		if strings.HasPrefix(text, "//") {
+			text = strings.TrimSuffix(text, "\n")
+		}
+		if p := fset.Position(end); p.Offset != epos.Offset+len(text) || p.Line != epos.Line+newlineCount(text) {
+			t.Errorf("bad end position for %q: got %d (line %d), expected %d (line %d)",
+				e.lit, p.Offset, p.Line, epos.Offset+len(text), epos.Line+newlineCount(text))
+		}
 		if tok != e.tok {
 			t.Errorf("bad token for %q: got %s, expected %s", e.lit, tok, e.tok)
 		}
@@ -213,19 +260,1981 @@ func TestScan(t *testing.T) {
 	}
 }
 
-func BenchmarkScan(b *testing.B) {
-	b.StopTimer()
+func TestScanExtended(t *testing.T) {
+	const src = "a\n  bc /*d*/ 世 \"e\" //f\r\n  1x\n$"
+	type extent struct {
+		tok        token.Token
+		start, end int
+	}
+	want := []extent{
+		{token.IDENT, 0, 1},
+		{token.INDENT, 4, 4},
+		{token.IDENT, 4, 6},
+		{token.COMMENT, 7, 12},
+		{token.IDENT, 13, 16},
+		{token.STRING, 17, 20},
+		{token.COMMENT, 21, 25},
+		{token.INT, 28, 29},
+		{token.IDENT, 29, 30},
+		{token.DEDENT, 31, 31},
+		{token.ILLEGAL, 31, 32},
+		{token.EOF, 32, 32},
+	}
+	for _, peek := range []bool{false, true} {
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(src))
+		var s Scanner
+		s.InitMode(file, []byte(src), nil, ScanIndent)
+		for i, w := range want {
+			if peek {
+				s.PeekToken()
+			}
+			pos, tok, _, end := s.ScanExtended()
+			if got := (extent{tok, file.Offset(pos), file.Offset(end)}); got != w {
+				t.Errorf("token %d (peek %v): got %v; expected %v", i, peek, got, w)
+			}
+		}
+	}
+}
+
+// TestPeekToken verifies that interleaving PeekToken and Scan calls
+// yields the same tokens and errors as calling Scan alone.
+func TestPeekToken(t *testing.T) {
 	fset := token.NewFileSet()
-	file := fset.AddFile("", fset.Base(), len(source))
 	var s Scanner
-	b.StartTimer()
-	for i := 0; i < b.N; i++ {
-		s.Init(file, source, nil)
+	s.Init(fset.AddFile("", fset.Base(), len(source)), source, nil)
+	for i := 0; ; i++ {
+		var ppos token.Pos
+		var ptok token.Token
+		var plit string
+		// Peek zero, one, or two times before each Scan.
+		for j := 0; j < i%3; j++ {
+			ppos, ptok, plit = s.PeekToken()
+		}
+		pos, tok, lit := s.Scan()
+		if i%3 > 0 && (pos != ppos || tok != ptok || lit != plit) {
+			t.Errorf("token %d: Scan returned %d %s %q, PeekToken returned %d %s %q", i, pos, tok, lit, ppos, ptok, plit)
+		}
+		e := elt{token.EOF, "", special}
+		if i < len(tokens) {
+			e = tokens[i]
+		}
+		if tok != e.tok {
+			t.Errorf("token %d: got %s, expected %s", i, tok, e.tok)
+		}
+		if tok == token.EOF {
+			break
+		}
+	}
+
+	for _, e := range errors {
+		var h errorCollector
+		eh := func(pos token.Position, msg string) {
+			h.cnt++
+			h.msg = msg
+		}
+		s.Init(fset.AddFile("", fset.Base(), len(e.src)), []byte(e.src), eh)
+		_, ptok, plit := s.PeekToken()
+		s.PeekToken()
+		_, tok, lit := s.Scan()
+		if tok != ptok || lit != plit {
+			t.Errorf("%q: Scan returned %s %q, PeekToken returned %s %q", e.src, tok, lit, ptok, plit)
+		}
+		cnt := 0
+		if e.err != "" {
+			cnt = 1
+		}
+		if h.cnt != cnt || s.ErrorCount != cnt || h.msg != e.err {
+			t.Errorf("%q: got %d errors (ErrorCount = %d, msg %q), expected %d (msg %q)", e.src, h.cnt, s.ErrorCount, h.msg, cnt, e.err)
+		}
+	}
+}
+
+func TestScanContext(t *testing.T) {
+	src := bytes.Repeat([]byte("a + b\n"), 10*ctxCheckInterval)
+	fset := token.NewFileSet()
+	var s Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := 0
+	for {
+		pos, tok, lit, err := s.ScanContext(ctx)
+		if err != nil {
+			if err != context.Canceled {
+				t.Errorf("got error %v, expected %v", err, context.Canceled)
+			}
+			if pos != token.NoPos || tok != token.EOF || lit != "" {
+				t.Errorf("got %d %s %q with error, expected NoPos EOF", pos, tok, lit)
+			}
+			break
+		}
+		if tok == token.EOF {
+			t.Fatalf("scanned to EOF despite cancellation")
+		}
+		if n++; n == 100 {
+			cancel()
+		}
+	}
+	if n != ctxCheckInterval {
+		t.Errorf("got %d tokens before cancellation took effect, expected %d", n, ctxCheckInterval)
+	}
+
+	// A context that is done already stops scanning immediately.
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil)
+	if _, _, _, err := s.ScanContext(ctx); err != context.Canceled {
+		t.Errorf("got error %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestAllowHash(t *testing.T) {
+	tests := []struct {
+		src  string
+		mode Mode
+		want string
+		errs int
+	}{
+		{"#[deprecated]", AllowHash, "[# [ IDENT ]]", 0},
+		{"#name #", AllowHash, "[# IDENT #]", 0},
+		{"#[deprecated]", 0, "[ILLEGAL [ IDENT ]]", 1},
+		{"#{1, 2}", AllowSetLiterals, "[#{ INT , INT }]", 0},
+		{"# {}", AllowSetLiterals, "[ILLEGAL { }]", 1},
+		{"#", AllowSetLiterals, "[ILLEGAL]", 1},
+		{"#{} #{", AllowSetLiterals | AllowHash, "[#{ } #{]", 0},
+		{"#{} #[", AllowHash, "[# { } # []", 0},
+		{"#{}", 0, "[ILLEGAL { }]", 1},
+	}
+	for _, test := range tests {
+		fset := token.NewFileSet()
+		var s Scanner
+		s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), nil, test.mode)
+		var toks []token.Token
+		for {
+			_, tok, _ := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			toks = append(toks, tok)
+		}
+		if got := fmt.Sprint(toks); got != test.want || s.ErrorCount != test.errs {
+			t.Errorf("%q, mode %d: got %s with %d errors; expected %s with %d errors", test.src, test.mode, got, s.ErrorCount, test.want, test.errs)
+		}
+	}
+	if !token.HASH.IsOperator() {
+		t.Errorf("HASH is not an operator")
+	}
+}
+
+func TestNilKeyword(t *testing.T) {
+	const src = "null nil nullable nil0 Nil"
+	for _, test := range []struct {
+		mode Mode
+		want string
+	}{
+		{0, "[NULL IDENT IDENT IDENT IDENT]"},
+		{NilKeyword, "[IDENT NULL IDENT IDENT IDENT]"},
+	} {
+		fset := token.NewFileSet()
+		var s Scanner
+		s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, test.mode)
+		var toks []token.Token
+		for {
+			_, tok, _ := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			toks = append(toks, tok)
+		}
+		if got := fmt.Sprint(toks); got != test.want {
+			t.Errorf("mode %d: got %s; expected %s", test.mode, got, test.want)
+		}
+	}
+}
+
+func TestSafeScan(t *testing.T) {
+	const src = "a b"
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s Scanner
+	s.Init(file, []byte(src), nil)
+	if pos, tok, lit, err := s.SafeScan(); err != nil || tok != token.IDENT || lit != "a" || pos != file.Pos(0) {
+		t.Errorf("got %d %s %q %v; expected %d IDENT \"a\" <nil>", pos, tok, lit, err, file.Pos(0))
+	}
+
+	corruptions := []func(s *Scanner){
+		func(s *Scanner) { s.offset = len(src) + 10 },
+		func(s *Scanner) { s.base = len(src) },
+		func(s *Scanner) { s.file = nil },
+		func(s *Scanner) {
+			s.ch = '\x00'
+			s.err = func(token.Position, string) { panic("handler") }
+		},
+	}
+	for i, corrupt := range corruptions {
+		s.Init(file, []byte(src), nil)
+		corrupt(&s)
+		pos, tok, lit, err := s.SafeScan()
+		if err == nil || pos != token.NoPos || tok != token.ILLEGAL || lit != "" {
+			t.Errorf("corruption %d: got %d %s %q %v; expected an error", i, pos, tok, lit, err)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	srcs := []string{string(source), "\ufeffa b ", "a & \"b"}
+	for _, src := range srcs {
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(src))
+		var s Scanner
+		s.Init(file, []byte(src), nil)
+		if want := len(src) - len(strings.TrimPrefix(src, "\ufeff")); s.Offset() != want {
+			t.Errorf("%.20q: got initial offset %d; expected %d", src, s.Offset(), want)
+		}
+		for {
+			_, tok, _, end := s.ScanExtended()
+			if s.Pos() != end || s.Offset() != file.Offset(end) {
+				t.Errorf("%.20q: got offset %d (pos %d) after %s; expected %d", src, s.Offset(), s.Pos(), tok, file.Offset(end))
+			}
+			if tok == token.EOF {
+				break
+			}
+		}
+		if s.Offset() != len(src) {
+			t.Errorf("%.20q: got offset %d after EOF; expected %d", src, s.Offset(), len(src))
+		}
+		s.Scan()
+		if s.Offset() != len(src) {
+			t.Errorf("%.20q: got offset %d after repeated EOF; expected %d", src, s.Offset(), len(src))
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	src := []byte("a := b")
+	fset := token.NewFileSet()
+	var s Scanner
+	s.Init(fset.AddFile("dir/x.zo", fset.Base(), len(src)), src, nil)
+	for i := 0; i < 2; i++ {
+		if got := s.Source(); len(got) != len(src) || &got[0] != &src[0] {
+			t.Errorf("got source %q at %p; expected %q at %p", got, got, src, src)
+		}
+		if got := s.Filename(); got != "dir/x.zo" {
+			t.Errorf("got filename %q; expected %q", got, "dir/x.zo")
+		}
+		s.Scan() // scanning does not change the source
+	}
+
+	// A range scanner holds the range of the source.
+	r := NewRangeScanner(fset.AddFile("y.zo", fset.Base(), len(src)), src, 2, 4, nil)
+	if got := r.Source(); string(got) != ":=" || &got[0] != &src[2] || r.Filename() != "y.zo" {
+		t.Errorf("range scanner: got source %q of %q", got, r.Filename())
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	check := func(name string, s *Scanner, size int) {
+		for {
+			if got := s.Remaining() + s.ScannedBytes(); got != size || got != len(s.Source()) {
+				t.Errorf("%s: at offset %d, got %d remaining and %d scanned bytes; expected %d in total",
+					name, s.Offset(), s.Remaining(), s.ScannedBytes(), size)
+			}
+			if _, tok := s.ScanNoLit(); tok == token.EOF {
+				break
+			}
+		}
+		if s.Remaining() != 0 || s.ScannedBytes() != size {
+			t.Errorf("%s: at EOF, got %d remaining and %d scanned bytes", name, s.Remaining(), s.ScannedBytes())
+		}
+	}
+	fset := token.NewFileSet()
+	var s Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(source)), source, nil)
+	if s.Remaining() != len(source) || s.ScannedBytes() != 0 {
+		t.Errorf("got %d remaining and %d scanned bytes before scanning", s.Remaining(), s.ScannedBytes())
+	}
+	check("Init", &s, len(source))
+
+	// A range scanner counts the bytes of the range.
+	src := []byte("a := b")
+	check("range", NewRangeScanner(fset.AddFile("", fset.Base(), len(src)), src, 2, 4, nil), 2)
+}
+
+func TestRangeScanner(t *testing.T) {
+	// Scan the middle third of source, from the start of a token to the
+	// start of another one.
+	all, _, allFile := tokenizeNew(source)
+	i, j := len(all)/3, 2*len(all)/3
+	start, end := allFile.Offset(all[i].Pos), allFile.Offset(all[j].Pos)
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(source))
+	s := NewRangeScanner(file, source, start, end, nil)
+	for k := i; k < j; k++ {
+		pos, tok, lit := s.Scan()
+		if want := all[k]; pos != want.Pos || tok != want.Tok || lit != want.Lit {
+			t.Fatalf("token %d: got %d %s %q; expected %d %s %q", k, pos, tok, lit, want.Pos, want.Tok, want.Lit)
+		}
+		if got, want := file.Position(pos), allFile.Position(all[k].Pos); got != want {
+			t.Errorf("token %d: got position %s; expected %s", k, got, want)
+		}
+	}
+	for n := 0; n < 2; n++ {
+		if pos, tok, _ := s.Scan(); tok != token.EOF || file.Offset(pos) != end {
+			t.Errorf("got %s at offset %d; expected EOF at %d", tok, file.Offset(pos), end)
+		}
+	}
+
+	// A token crossing the end of the range is cut off.
+	const src = "abc def"
+	fset = token.NewFileSet()
+	file = fset.AddFile("", fset.Base(), len(src))
+	s = NewRangeScanner(file, []byte(src), 1, 5, nil)
+	for _, want := range []TokenInfo{{file.Pos(1), token.IDENT, "bc"}, {file.Pos(4), token.IDENT, "d"}, {file.Pos(5), token.EOF, ""}} {
+		if pos, tok, lit := s.Scan(); pos != want.Pos || tok != want.Tok || lit != want.Lit {
+			t.Errorf("got %d %s %q; expected %d %s %q", pos, tok, lit, want.Pos, want.Tok, want.Lit)
+		}
+	}
+}
+
+func TestScanColon(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		toks []token.Token
+	}{
+		{":", []token.Token{token.COLON}},
+		{":=", []token.Token{token.DEFINE}},
+		{"::", []token.Token{token.COLON, token.COLON}},
+		{": =", []token.Token{token.COLON, token.ASSIGN}},
+		{":==", []token.Token{token.DEFINE, token.ASSIGN}},
+		{"a:b", []token.Token{token.IDENT, token.COLON, token.IDENT}},
+		{"a:=b", []token.Token{token.IDENT, token.DEFINE, token.IDENT}},
+		{"a::b", []token.Token{token.IDENT, token.COLON, token.COLON, token.IDENT}},
+	} {
+		fset := token.NewFileSet()
+		var s Scanner
+		s.Init(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), nil)
+		var toks []token.Token
 		for {
 			_, tok, _ := s.Scan()
 			if tok == token.EOF {
 				break
 			}
+			toks = append(toks, tok)
+		}
+		if fmt.Sprint(toks) != fmt.Sprint(test.toks) {
+			t.Errorf("%q: got %v, expected %v", test.src, toks, test.toks)
+		}
+	}
+}
+
+func TestPeek(t *testing.T) {
+	const src = "a +b\t//c"
+	fset := token.NewFileSet()
+	var s Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil)
+	for _, want := range []struct {
+		ch  rune
+		tok token.Token
+	}{
+		{'a', token.IDENT},
+		{' ', token.ADD},
+		{'b', token.IDENT},
+		{'\t', token.COMMENT},
+		{-1, token.EOF},
+		{-1, token.EOF},
+	} {
+		if ch := s.Peek(); ch != want.ch {
+			t.Errorf("got Peek() = %q, expected %q", ch, want.ch)
+		}
+		if ch := s.Peek(); ch != want.ch {
+			t.Errorf("got second Peek() = %q, expected %q", ch, want.ch)
+		}
+		if _, tok, _ := s.Scan(); tok != want.tok {
+			t.Errorf("got %s, expected %s", tok, want.tok)
+		}
+	}
+	if s.ErrorCount != 0 {
+		t.Errorf("found %d errors", s.ErrorCount)
+	}
+}
+
+func TestScanCommentText(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		mode Mode
+		lit  string
+	}{
+		{"/* a comment */", 0, ""},
+		{"/* a comment */", RetainCommentText, "/* a comment */"},
+		{"// a comment \n", RetainCommentText, "// a comment "},
+		{"/*\r*/", RetainCommentText, "/*\r*/"},
+		{"/*\r\n*/", RetainCommentText, "/*\r\n*/"},
+		{"//\r\n", RetainCommentText, "//\r"},
+		{"/*\r*/", RetainCommentText | StripCommentCR, "/**/"},
+		{"/*\r\n*/", RetainCommentText | StripCommentCR, "/*\n*/"},
+		{"//\r\n", RetainCommentText | StripCommentCR, "//"},
+		{"/*\r\n*/", StripCommentCR, ""},
+		{"/* unterminated", RetainCommentText, "/* unterminated"},
+		{"/* line 1\n * line 2\n */", RetainCommentText, "/* line 1\n * line 2\n */"},
+		{"/* a */ b", RetainCommentText, "/* a */"},
+		{"// a\n// b", RetainCommentText, "// a"},
+	} {
+		fset := token.NewFileSet()
+		var s Scanner
+		s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), nil, test.mode)
+		_, tok, lit := s.Scan()
+		if tok != token.COMMENT || lit != test.lit {
+			t.Errorf("%q (mode %d): got %s %q, expected COMMENT %q", test.src, test.mode, tok, lit, test.lit)
+		}
+	}
+}
+
+func TestLinePragmas(t *testing.T) {
+	// Malformed line comments (no filename, no line, no colon) are ignored.
+	const src = "a\n//line foo.zo:10\nb c\nd /*line bar.zo:20*/e\nf\n//line :30\ng\n//line foo.zo\nh //line baz.zo:1\n/*line x*/i"
+	for _, test := range []struct {
+		filename string
+		mode     Mode
+		lines    []string
+	}{
+		{"", ParseLinePragmas, []string{"1:1", "foo.zo:10:1", "foo.zo:10:3", "foo.zo:11:1", "bar.zo:20:21", "bar.zo:21:1", "bar.zo:23:1", "bar.zo:25:1", "baz.zo:1:11"}},
+		{"dir/src.zo", ParseLinePragmas, []string{"dir/src.zo:1:1", "dir/foo.zo:10:1", "dir/foo.zo:10:3", "dir/foo.zo:11:1", "dir/bar.zo:20:21", "dir/bar.zo:21:1", "dir/bar.zo:23:1", "dir/bar.zo:25:1", "dir/baz.zo:1:11"}},
+		{"src.zo", 0, []string{"src.zo:1:1", "src.zo:3:1", "src.zo:3:3", "src.zo:4:1", "src.zo:4:21", "src.zo:5:1", "src.zo:7:1", "src.zo:9:1", "src.zo:10:11"}},
+	} {
+		fset := token.NewFileSet()
+		var s Scanner
+		s.InitMode(fset.AddFile(test.filename, fset.Base(), len(src)), []byte(src), nil, test.mode)
+		var lines []string
+		for {
+			pos, tok, _ := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			if tok == token.IDENT {
+				lines = append(lines, fset.Position(pos).String())
+			}
+		}
+		if fmt.Sprint(lines) != fmt.Sprint(test.lines) {
+			t.Errorf("%q (mode %d): got %v, expected %v", test.filename, test.mode, lines, test.lines)
+		}
+	}
+
+	// Line comments apply to the line following them for all line endings.
+	for _, eol := range []string{"\r\n", "\r"} {
+		const want = "[a:1:1 foo.zo:10:1 foo.zo:11:1 bar.zo:21:1]"
+		src := strings.Replace("a\n//line foo.zo:10\nb\nc /*line bar.zo:20*/\nd", "\n", eol, -1)
+		for _, rd := range []bool{false, true} {
+			fset := token.NewFileSet()
+			var s Scanner
+			if rd {
+				s.InitReader(fset.AddFile("a", fset.Base(), 0), iotest.OneByteReader(strings.NewReader(src)), nil, ParseLinePragmas|CRLineEndings)
+			} else {
+				s.InitMode(fset.AddFile("a", fset.Base(), len(src)), []byte(src), nil, ParseLinePragmas|CRLineEndings)
+			}
+			var lines []string
+			for {
+				pos, tok, _ := s.Scan()
+				if tok == token.EOF {
+					break
+				}
+				if tok == token.IDENT && fset.Position(pos).Column == 1 {
+					lines = append(lines, fset.Position(pos).String())
+				}
+			}
+			if fmt.Sprint(lines) != want {
+				t.Errorf("%q (reader %v): got %v, expected %v", src, rd, lines, want)
+			}
+		}
+	}
+}
+
+func TestLinePragmaInfos(t *testing.T) {
+	const src = "a\n//line foo.zo:10\nb /*line bar.zo:20*/c\n//line :30\nd"
+	fset := token.NewFileSet()
+	file := fset.AddFile("dir/src.zo", fset.Base(), len(src))
+	var s Scanner
+	s.InitMode(file, []byte(src), nil, ParseLinePragmas)
+	for {
+		if _, tok, _ := s.Scan(); tok == token.EOF {
+			break
+		}
+	}
+	want := []token.LineInfo{
+		{Offset: 19, Filename: "dir/foo.zo", Line: 10},
+		{Offset: 39, Filename: "dir/bar.zo", Line: 20}, // immediately after the comment
+	}
+	if got := file.LineInfos(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got line infos %v, expected %v", got, want)
+	}
+}
+
+func TestCRLineEndings(t *testing.T) {
+	// Tokens, literals, lines, and columns are the same for all line endings.
+	const src = "a // b\n\"c\n\td\n\n  e 'f\ng"
+	for _, mode := range []Mode{CRLineEndings, CRLineEndings | RetainCommentText, CRLineEndings | ScanIndent} {
+		var want string
+		for _, eol := range []string{"\n", "\r\n", "\r"} {
+			src := strings.Replace(src, "\n", eol, -1)
+			fset := token.NewFileSet()
+			file := fset.AddFile("", fset.Base(), len(src))
+			var s Scanner
+			s.InitMode(file, []byte(src), nil, mode)
+			var buf bytes.Buffer
+			for {
+				pos, tok, lit := s.Scan()
+				if tok == token.EOF {
+					break
+				}
+				p := fset.Position(pos)
+				fmt.Fprintf(&buf, "%d:%d %s %q\n", p.Line, p.Column, tok, lit)
+			}
+			fmt.Fprintf(&buf, "%d lines, %d errors\n", file.LineCount(), s.ErrorCount)
+			if eol == "\n" {
+				want = buf.String()
+			} else if got := buf.String(); got != want {
+				t.Errorf("%q, mode %d: got\n%s\nexpected\n%s", src, mode, got, want)
+			}
+		}
+	}
+
+	// By default, a lone '\r' is white space within a line.
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), 3)
+	var s Scanner
+	s.Init(file, []byte("a\rb"), nil)
+	s.Scan()
+	if pos, _, _ := s.Scan(); fset.Position(pos).Line != 1 || file.LineCount() != 1 {
+		t.Errorf("got %s with %d lines; expected line 1 of 1", fset.Position(pos), file.LineCount())
+	}
+}
+
+func TestStripCR(t *testing.T) {
+	for _, test := range []struct{ src, want string }{
+		{"", ""},
+		{"abc", "abc"},
+		{"\r", ""},
+		{"a\r\nb\r\n", "a\nb\n"},
+		{"\r\r\nx\r", "\nx"},
+	} {
+		src := []byte(test.src)
+		if got := string(StripCR(src)); got != test.want {
+			t.Errorf("StripCR(%q) = %q, expected %q", test.src, got, test.want)
+		}
+		if string(src) != test.src {
+			t.Errorf("StripCR(%q) modified its argument", test.src)
+		}
+	}
+}
+
+// scanTrace initializes a scanner via init and returns a line for each
+// token and error, in the order they are produced.
+func scanTrace(fset *token.FileSet, init func(s *Scanner, err ErrorHandler)) string {
+	var buf bytes.Buffer
+	var s Scanner
+	init(&s, func(pos token.Position, msg string) {
+		fmt.Fprintf(&buf, "error %s: %s\n", pos, msg)
+	})
+	for {
+		pos, tok, lit := s.Scan()
+		fmt.Fprintf(&buf, "%s %s %q\n", fset.Position(pos), tok, lit)
+		if tok == token.EOF {
+			break
+		}
+	}
+	fmt.Fprintf(&buf, "%d errors, size %d, %d lines\n", s.ErrorCount, s.file.Size(), s.file.LineCount())
+	return buf.String()
+}
+
+func TestInitDetect(t *testing.T) {
+	const text = "x := \"日本\" + 𝒳 // ü\n'a' \x7f"
+	encode := func(bigEndian bool) []byte {
+		b := []byte{0xff, 0xfe}
+		if bigEndian {
+			b = []byte{0xfe, 0xff}
+		}
+		for _, r := range text {
+			var u []uint16
+			if r >= 0x10000 {
+				r -= 0x10000
+				u = []uint16{uint16(0xd800 + r>>10), uint16(0xdc00 + r&0x3ff)}
+			} else {
+				u = []uint16{uint16(r)}
+			}
+			for _, x := range u {
+				if bigEndian {
+					b = append(b, byte(x>>8), byte(x))
+				} else {
+					b = append(b, byte(x), byte(x>>8))
+				}
+			}
+		}
+		return b
+	}
+	scan := func(src []byte) (string, []byte) {
+		fset := token.NewFileSet()
+		var text []byte
+		trace := scanTrace(fset, func(s *Scanner, err ErrorHandler) {
+			_, text = s.InitDetect(fset, "x.zo", src, err, 0)
+		})
+		return trace, text
+	}
+
+	want, text0 := scan([]byte(text))
+	if string(text0) != text {
+		t.Errorf("UTF-8 text changed to %q", text0)
+	}
+	for _, bigEndian := range []bool{false, true} {
+		got, text := scan(encode(bigEndian))
+		if got != want || string(text) != string(text0) {
+			t.Errorf("UTF-16 (big endian: %v): got\n%s %q\nexpected\n%s", bigEndian, got, text, want)
+		}
+	}
+
+	// UTF-8 with a byte order mark is scanned as by Init.
+	src := []byte("\ufeff" + text)
+	got, text1 := scan(src)
+	fset := token.NewFileSet()
+	if want := scanTrace(fset, func(s *Scanner, err ErrorHandler) {
+		s.Init(fset.AddFile("x.zo", fset.Base(), len(src)), src, err)
+	}); got != want || &text1[0] != &src[0] {
+		t.Errorf("UTF-8 with BOM: got\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestInitFilter(t *testing.T) {
+	// The filter strips a header and expands tabs, changing the length.
+	strip := func(name string, src []byte) ([]byte, error) {
+		if name != "x.zo" {
+			t.Errorf("filter called with name %q", name)
+		}
+		if !bytes.HasPrefix(src, []byte("HDR\n")) {
+			return nil, fmt.Errorf("missing header")
+		}
+		return bytes.Replace(src[4:], []byte("\t"), []byte("    "), -1), nil
+	}
+	const text = "a\n\tb @"
+	fset := token.NewFileSet()
+	var list ErrorList
+	var s Scanner
+	file, src := s.InitFilter(fset, "x.zo", []byte("HDR\n"+text), strip, func(pos token.Position, msg string) { list.Add(pos, msg) }, 0)
+	if string(src) != "a\n    b @" || file.Size() != len(src) {
+		t.Fatalf("got text %q, file size %d", src, file.Size())
+	}
+	var got []string
+	for {
+		pos, tok, _ := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		got = append(got, fmt.Sprintf("%s %s", fset.Position(pos), tok))
+	}
+	if want := "[x.zo:1:1 IDENT x.zo:2:5 IDENT x.zo:2:7 ILLEGAL]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v; expected %s", got, want)
+	}
+	if len(list) != 1 || list[0].Pos.Offset != 8 {
+		t.Errorf("got errors %v; expected one at offset 8", list)
+	}
+
+	// A filter error is reported at the beginning of the empty file.
+	list = nil
+	file, src = s.InitFilter(fset, "x.zo", []byte(text), strip, func(pos token.Position, msg string) { list.Add(pos, msg) }, 0)
+	if len(src) != 0 || file.Size() != 0 {
+		t.Errorf("got text %q, file size %d after a filter error", src, file.Size())
+	}
+	if _, tok, _ := s.Scan(); tok != token.EOF {
+		t.Errorf("got %s after a filter error; expected EOF", tok)
+	}
+	if s.ErrorCount != 1 || len(list) != 1 || list[0].Error() != "x.zo:1:1: source filter error: missing header" {
+		t.Errorf("got %d errors %v; expected the filter error", s.ErrorCount, list)
+	}
+}
+
+func TestSkipShebang(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		mode Mode
+		toks string
+		errs int
+	}{
+		{"\ufeff#!zolang\nfoo", SkipShebang, "[2:1 IDENT foo]", 0},
+		{"#!/usr/bin/env zolang -x\n\tfoo bar", SkipShebang, "[2:2 IDENT foo 2:6 IDENT bar]", 0},
+		{"#!zolang\r\nfoo", SkipShebang, "[2:1 IDENT foo]", 0},
+		{"#!zolang\rfoo", SkipShebang | CRLineEndings, "[2:1 IDENT foo]", 0},
+		{"#!zolang\n#!x", SkipShebang | AllowHash, "[2:1 # 2:2 ! 2:3 IDENT x]", 0},
+		{"#!zolang", SkipShebang, "[]", 0},
+		{"#zolang\nfoo", SkipShebang, "[1:1 ILLEGAL # 1:2 IDENT zolang 2:1 IDENT foo]", 1},
+		{" #!zolang", SkipShebang, "[1:2 ILLEGAL # 1:3 ! 1:4 IDENT zolang]", 1},
+		{"#!zolang", 0, "[1:1 ILLEGAL # 1:2 ! 1:3 IDENT zolang]", 1},
+	} {
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(test.src))
+		var s Scanner
+		s.InitMode(file, []byte(test.src), nil, test.mode)
+		scan := func() string {
+			var toks []string
+			for {
+				pos, tok, lit := s.Scan()
+				if tok == token.EOF {
+					break
+				}
+				p := file.Position(pos)
+				toks = append(toks, strings.TrimSpace(fmt.Sprintf("%d:%d %s %s", p.Line, p.Column, tok, lit)))
+			}
+			return fmt.Sprint(toks)
+		}
+		if got := scan(); got != test.toks || s.ErrorCount != test.errs {
+			t.Errorf("%q: got %s with %d errors; expected %s with %d", test.src, got, s.ErrorCount, test.toks, test.errs)
+		}
+		// Seeking to the beginning skips the prelude again.
+		s.Seek(0)
+		if got := scan(); got != test.toks {
+			t.Errorf("%q: got %s after Seek(0); expected %s", test.src, got, test.toks)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	src := []byte(strings.Repeat("abc 123 // comment\n", 1000))
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var offsets []int
+	var s Scanner
+	s.Progress = func(offset, total int) {
+		if total != len(src) {
+			t.Errorf("got total %d; expected %d", total, len(src))
+		}
+		offsets = append(offsets, offset)
+	}
+	s.ProgressInterval = 1000
+	s.Init(file, src, nil)
+	for {
+		if _, tok := s.ScanNoLit(); tok == token.EOF {
+			break
+		}
+	}
+	s.Scan() // EOF is reported once only
+	if len(offsets) < len(src)/1000-1 || len(offsets) > len(src)/1000+1 {
+		t.Fatalf("got %d calls; expected about %d", len(offsets), len(src)/1000+1)
+	}
+	prev := 0
+	for i, offs := range offsets[:len(offsets)-1] {
+		if offs-prev < 1000 {
+			t.Errorf("call %d: got offset %d after %d; expected at least %d", i, offs, prev, prev+1000)
+		}
+		prev = offs
+	}
+	if last := offsets[len(offsets)-1]; last != len(src) {
+		t.Errorf("got last offset %d; expected %d", last, len(src))
+	}
+
+	// With the default interval, a small source reports EOF only.
+	offsets = nil
+	s.ProgressInterval = 0
+	s.Init(file, src, nil)
+	for {
+		if _, tok, _ := s.Scan(); tok == token.EOF {
+			break
+		}
+	}
+	if fmt.Sprint(offsets) != fmt.Sprintf("[%d]", len(src)) {
+		t.Errorf("got offsets %v; expected [%d]", offsets, len(src))
+	}
+}
+
+func TestOnLine(t *testing.T) {
+	const src = "a\nb /* c\nd */ \"e\"\n\n// f\ng\n"
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var got []string
+	var s Scanner
+	s.OnLine(func(line, offset int) {
+		got = append(got, fmt.Sprintf("%d@%d", line, offset))
+	})
+	scan := func() {
+		for {
+			if _, tok, _ := s.Scan(); tok == token.EOF {
+				break
+			}
+		}
+	}
+	s.Init(file, []byte(src), nil)
+	scan()
+	if want := "[2@2 3@9 4@18 5@19 6@24]"; fmt.Sprint(got) != want {
+		t.Errorf("got lines %v; expected %s", got, want)
+	}
+	if len(got)+1 != file.LineCount() {
+		t.Errorf("got %d callbacks for %d lines", len(got), file.LineCount())
+	}
+
+	// Lines already in the line table are not reported again.
+	got = nil
+	s.Seek(0)
+	scan()
+	s.Init(file, []byte(src), nil)
+	scan()
+	if len(got) != 0 {
+		t.Errorf("got lines %v when scanning again", got)
+	}
+
+	// A nil callback removes it.
+	s.OnLine(nil)
+	file = fset.AddFile("", fset.Base(), len(src))
+	s.Init(file, []byte(src), nil)
+	scan()
+	if len(got) != 0 || file.LineCount() != 6 {
+		t.Errorf("got lines %v without callback, and %d lines", got, file.LineCount())
+	}
+}
+
+func TestIsIdent(t *testing.T) {
+	for _, test := range []struct {
+		ch          rune
+		start, cont bool
+	}{
+		{'a', true, true},
+		{'z', true, true},
+		{'A', true, true},
+		{'Z', true, true},
+		{'_', true, true},
+		{'0', false, true},
+		{'9', false, true},
+		{'ä', true, true},
+		{'世', true, true},
+		{'а', true, true},        // Cyrillic
+		{'٣', false, true},       // Arabic-Indic digit three
+		{'\uff10', false, true},  // fullwidth digit zero
+		{'\u0301', false, false}, // combining acute accent
+		{'$', false, false},
+		{'-', false, false},
+		{'.', false, false},
+		{'#', false, false},
+		{'\'', false, false},
+		{' ', false, false},
+		{'\u00b7', false, false}, // middle dot
+		{utf8.RuneError, false, false},
+		{-1, false, false},
+	} {
+		if got := IsIdentStart(test.ch); got != test.start {
+			t.Errorf("IsIdentStart(%q) = %v, want %v", test.ch, got, test.start)
+		}
+		if got := IsIdentContinue(test.ch); got != test.cont {
+			t.Errorf("IsIdentContinue(%q) = %v, want %v", test.ch, got, test.cont)
+		}
+	}
+}
+
+func TestIsWhitespace(t *testing.T) {
+	for _, ch := range []rune{' ', '\t', '\n', '\r'} {
+		if !IsWhitespace(ch) {
+			t.Errorf("IsWhitespace(%q) = false", ch)
+		}
+		if got := SkipWhitespace([]byte{'a', byte(ch), byte(ch), 'b'}, 1); got != 3 {
+			t.Errorf("%q: got offset %d; expected 3", ch, got)
+		}
+	}
+	for _, ch := range []rune{'a', '_', '/', 0, '\v', '\f', 0xa0, bom, 0x2028, -1} {
+		if IsWhitespace(ch) {
+			t.Errorf("IsWhitespace(%q) = true", ch)
+		}
+	}
+
+	for _, test := range []struct {
+		src         string
+		offset, end int
+	}{
+		{"", 0, 0},
+		{" \t\r\n", 0, 4},
+		{" \t\r\n", 2, 4},
+		{"a  b", 0, 0},
+		{"a  b", 1, 3},
+		{"a  ", 1, 3},
+		{"a \v b", 1, 2},     // vertical tab is not white space
+		{"a \ufeff b", 1, 2}, // neither is a byte order mark
+		{"a \u00a0 b", 1, 2}, // nor a no-break space
+		{"a // b\n c", 1, 2}, // comments are not skipped
+		{"a \xff", 1, 2},
+	} {
+		if got := SkipWhitespace([]byte(test.src), test.offset); got != test.end {
+			t.Errorf("SkipWhitespace(%q, %d) = %d; expected %d", test.src, test.offset, got, test.end)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("SkipWhitespace with offset out of range did not panic")
+		}
+	}()
+	SkipWhitespace([]byte("a"), 2)
+}
+
+func TestInitChecked(t *testing.T) {
+	fset := token.NewFileSet()
+	for _, test := range []struct {
+		file *token.File
+		src  string
+		err  string
+	}{
+		{fset.AddFile("", fset.Base(), 3), "a b", ""},
+		{fset.AddFile("", fset.Base(), 4), "\ufeffa", ""},
+		{fset.AddFile("", fset.Base(), 2), "a b", "file size (2) does not match src len (3)"},
+		{nil, "a b", "nil file"},
+		{fset.AddFile("", fset.Base(), 3), "\xfe\xffa", "src starts with a UTF-16 byte order mark; expected UTF-8"},
+		{fset.AddFile("", fset.Base(), 3), "\xff\xfea", "src starts with a UTF-16 byte order mark; expected UTF-8"},
+	} {
+		var s Scanner
+		err := s.InitChecked(test.file, []byte(test.src), nil, 0)
+		if err == nil && test.err != "" || err != nil && err.Error() != test.err {
+			t.Errorf("%q: got error %v; expected %q", test.src, err, test.err)
+			continue
+		}
+		if err != nil {
+			if s.file != nil {
+				t.Errorf("%q: scanner initialized despite error", test.src)
+			}
+			continue
+		}
+		// The scanner is initialized as by Init.
+		if _, tok, lit := s.Scan(); tok != token.IDENT || lit != "a" {
+			t.Errorf("%q: got %s %q; expected IDENT \"a\"", test.src, tok, lit)
+		}
+	}
+
+	// Init panics with the same error.
+	defer func() {
+		if r := recover(); r != "nil file" {
+			t.Errorf("got panic %v; expected \"nil file\"", r)
+		}
+	}()
+	var s Scanner
+	s.Init(nil, nil, nil)
+}
+
+func TestInitReader(t *testing.T) {
+	srcs := []string{
+		string(source),
+		strings.Repeat("äöü 世界 'ä' \"日本語\" r'α' /* ß */ a:=b!=c\n", 500), // > minRead
+		"\ufeffx\xffy\x00",
+		"//line foo.zo:10\nx\n/*line bar.zo:20*/y",
+	}
+	for _, e := range errors {
+		srcs = append(srcs, e.src)
+	}
+	for _, test := range indentTests {
+		srcs = append(srcs, test.src)
+	}
+	readers := []struct {
+		name string
+		r    func(io.Reader) io.Reader
+	}{
+		{"Reader", func(r io.Reader) io.Reader { return r }},
+		{"OneByteReader", iotest.OneByteReader},
+		{"HalfReader", iotest.HalfReader},
+		{"DataErrReader", iotest.DataErrReader},
+	}
+	modes := []Mode{0, ScanIndent, RetainCommentText | ParseLinePragmas}
+	for _, src := range srcs {
+		for _, mode := range modes {
+			fset := token.NewFileSet()
+			want := scanTrace(fset, func(s *Scanner, err ErrorHandler) {
+				s.InitMode(fset.AddFile("test.zo", fset.Base(), len(src)), []byte(src), err, mode)
+			})
+			for _, rd := range readers {
+				fset := token.NewFileSet()
+				got := scanTrace(fset, func(s *Scanner, err ErrorHandler) {
+					s.InitReader(fset.AddFile("test.zo", fset.Base(), 0), rd.r(strings.NewReader(src)), err, mode)
+				})
+				if got != want {
+					t.Errorf("%s, mode %d, %.20q:\ngot:\n%s\nwant:\n%s", rd.name, mode, src, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestInitReaderWindow(t *testing.T) {
+	src := strings.Repeat("abc 世界 'x' \"yz\"\n", 10000)
+	fset := token.NewFileSet()
+	var s Scanner
+	s.InitReader(fset.AddFile("test.zo", fset.Base(), 0), strings.NewReader(src), nil, 0)
+	n := 0
+	for {
+		_, tok, _ := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if c := cap(s.src); c > 4*minRead {
+			t.Fatalf("got buffer capacity %d after %d tokens; want <= %d", c, n, 4*minRead)
+		}
+		n++
+	}
+	if n != 40000 {
+		t.Errorf("got %d tokens; want 40000", n)
+	}
+}
+
+func TestInitReaderError(t *testing.T) {
+	fset := token.NewFileSet()
+	var list ErrorList
+	var s Scanner
+	s.InitReader(fset.AddFile("test.zo", fset.Base(), 0), iotest.TimeoutReader(strings.NewReader("a b")),
+		func(pos token.Position, msg string) { list.Add(pos, msg) }, 0)
+	var toks []token.Token
+	for {
+		_, tok, _ := s.Scan()
+		toks = append(toks, tok)
+		if tok == token.EOF {
+			break
+		}
+	}
+	if fmt.Sprint(toks) != "[IDENT IDENT EOF]" {
+		t.Errorf("got tokens %v; want [IDENT IDENT EOF]", toks)
+	}
+	if len(list) != 1 || list[0].Msg != "read error: timeout" || list[0].Pos.Offset != 3 {
+		t.Errorf("got errors %v; want read error at offset 3", list)
+	}
+}
+
+// scanRest scans the remaining tokens of s and returns them as a string.
+func scanRest(s *Scanner) string {
+	var buf bytes.Buffer
+	for {
+		pos, tok, lit := s.Scan()
+		fmt.Fprintf(&buf, "%d %s %q\n", pos, tok, lit)
+		if tok == token.EOF {
+			return buf.String()
+		}
+	}
+}
+
+// scanErrRest is like scanRest but peeks at each token before scanning
+// it with ScanErr, and includes the errors of each token.
+func scanErrRest(s *Scanner) string {
+	var buf bytes.Buffer
+	for {
+		s.PeekToken()
+		pos, tok, lit, err := s.ScanErr()
+		fmt.Fprintf(&buf, "%d %s %q %v\n", pos, tok, lit, err)
+		if tok == token.EOF {
+			return buf.String()
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	srcs := []string{"a \"b\" /* c */\nd 1x e\n'f\n", "\x00a 'b\n"}
+	for _, test := range indentTests {
+		srcs = append(srcs, test.src)
+	}
+	for _, src := range srcs {
+		for _, mode := range []Mode{0, ScanIndent} {
+			for n := 0; n < 4; n++ {
+				fset := token.NewFileSet()
+				file := fset.AddFile("", fset.Base(), len(src))
+				var s Scanner
+				handled := 0
+				s.InitMode(file, []byte(src), func(token.Position, string) { handled++ }, mode)
+				for i := 0; i < n; i++ {
+					s.Scan()
+				}
+				if n == 2 {
+					s.PeekToken() // checkpoint with a pending token
+				}
+				cp := s.Checkpoint()
+				errs, handled0 := s.ErrorCount, handled
+				want := scanRest(&s)
+				wantErrs, handled1, lines := s.ErrorCount, handled, file.LineCount()
+
+				s.Restore(cp)
+				if s.ErrorCount != errs {
+					t.Errorf("%q, %d tokens: got ErrorCount %d after Restore; expected %d", src, n, s.ErrorCount, errs)
+				}
+				if got := scanRest(&s); got != want {
+					t.Errorf("%q, %d tokens: got\n%s\nafter Restore; expected\n%s", src, n, got, want)
+				}
+				if s.ErrorCount != wantErrs || handled-handled1 != handled1-handled0 {
+					t.Errorf("%q, %d tokens: got ErrorCount %d and %d reports after Restore; expected %d and %d",
+						src, n, s.ErrorCount, handled-handled1, wantErrs, handled1-handled0)
+				}
+				// Lines scanned again are not added again.
+				if file.LineCount() != lines {
+					t.Errorf("%q, %d tokens: got %d lines after Restore; expected %d", src, n, file.LineCount(), lines)
+				}
+
+				// Errors returned by ScanErr, also for a token scanned
+				// ahead when the checkpoint was taken.
+				s.Restore(cp)
+				want = scanErrRest(&s)
+				s.Restore(cp)
+				if got := scanErrRest(&s); got != want {
+					t.Errorf("%q, %d tokens: got\n%s\nfrom ScanErr after Restore; expected\n%s", src, n, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCheckpointReader(t *testing.T) {
+	fset := token.NewFileSet()
+	var s Scanner
+	s.InitReader(fset.AddFile("", fset.Base(), 0), iotest.OneByteReader(strings.NewReader("a b c d")), nil, 0)
+	s.Scan()
+	cp := s.Checkpoint()
+	s.Scan()
+	s.Restore(cp)
+	if _, _, lit := s.Scan(); lit != "b" {
+		t.Errorf("got %q after Restore; expected %q", lit, "b")
+	}
+
+	var t0 Scanner
+	t0.Init(fset.AddFile("", fset.Base(), 0), nil, nil)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Restore of another scanner's checkpoint did not panic")
+		}
+	}()
+	t0.Restore(cp)
+}
+
+func TestSeek(t *testing.T) {
+	srcs := []string{string(source), "a\n世界 x\xe4y \"s\" 'c' /* d */ e // f\n"}
+	for _, src := range srcs {
+		toks, _, _ := tokenizeNew([]byte(src))
+		for i := range toks {
+			fset := token.NewFileSet()
+			file := fset.AddFile("", fset.Base(), len(src))
+			var s Scanner
+			s.Init(file, []byte(src), nil)
+			for j := 0; j < i; j++ {
+				s.Scan()
+			}
+			want := scanRest(&s)
+
+			// Seek forward from the start and backwards from the end.
+			offs := file.Offset(toks[i].Pos)
+			s.Init(file, []byte(src), nil)
+			s.Seek(offs)
+			if got := scanRest(&s); got != want {
+				t.Errorf("%.20q: after Seek(%d), got\n%s\nexpected\n%s", src, offs, got, want)
+			}
+			s.Seek(offs)
+			if got := scanRest(&s); got != want {
+				t.Errorf("%.20q: after Seek(%d) at EOF, got\n%s\nexpected\n%s", src, offs, got, want)
+			}
+		}
+	}
+
+	// Seeking forward on a fresh file adds the lines skipped.
+	for _, test := range []struct {
+		src    string
+		mode   Mode
+		offset int
+		want   string // positions of the tokens after Seek
+	}{
+		{"a\nb\nc\nd", 0, 4, "[3:1 4:1 4:2]"},
+		{"a\nb\nc\nd", 0, 3, "[3:1 4:1 4:2]"},
+		{"a\r\nb\rc\nd", CRLineEndings, 5, "[3:1 4:1 4:2]"},
+		{"a\r\nb\rc\nd", CRLineEndings, 2, "[2:1 3:1 4:1 4:2]"},
+		{"a\r\nb\rc\nd", 0, 5, "[2:3 3:1 3:2]"},
+	} {
+		fset := token.NewFileSet()
+		var s Scanner
+		s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), nil, test.mode)
+		s.Seek(test.offset)
+		var got []string
+		for {
+			pos, tok, _ := s.Scan()
+			p := fset.Position(pos)
+			got = append(got, fmt.Sprintf("%d:%d", p.Line, p.Column))
+			if tok == token.EOF {
+				break
+			}
+		}
+		if fmt.Sprint(got) != test.want {
+			t.Errorf("%q: after Seek(%d), got positions %v; expected %s", test.src, test.offset, got, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		src    string
+		offset int
+		ok     bool
+	}{
+		{"世界", 3, true},
+		{"世界", 1, false},
+		{"世界", 5, false},
+		{"\xe4\xb8x", 1, true}, // illegal encoding
+		{"ab", 2, true},
+		{"ab", 3, false},
+		{"ab", -1, false},
+	} {
+		fset := token.NewFileSet()
+		var s Scanner
+		s.Init(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), nil)
+		func() {
+			defer func() {
+				if r := recover(); (r == nil) != test.ok {
+					t.Errorf("%q: Seek(%d) panicked with %v; expected panic: %v", test.src, test.offset, r, !test.ok)
+				}
+			}()
+			s.Seek(test.offset)
+		}()
+	}
+}
+
+func TestAdjacentToPrevious(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []bool
+	}{
+		{`"a" "b"`, []bool{false, true}},
+		{`"a" + "b"`, []bool{false, false, false}},
+		{"\"a\"\n\t\"b\"\"c\"", []bool{false, true, true}},
+		{`"a" /* b */ "c"`, []bool{false, false, false}},
+		{`"a" 'b' "c"`, []bool{false, false, false}},
+		{`x "a"`, []bool{false, false}},
+	}
+	for _, test := range tests {
+		for _, peek := range []bool{false, true} {
+			fset := token.NewFileSet()
+			var s Scanner
+			s.Init(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), nil)
+			var got []bool
+			for {
+				if peek {
+					s.PeekToken() // must not affect the result
+				}
+				_, tok, _ := s.Scan()
+				if tok == token.EOF {
+					if s.AdjacentToPrevious() {
+						t.Errorf("%s: EOF is adjacent", test.src)
+					}
+					break
+				}
+				got = append(got, s.AdjacentToPrevious())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("%s (peek %v): got %v; expected %v", test.src, peek, got, test.want)
+			}
+		}
+	}
+}
+
+func TestRetainTrivia(t *testing.T) {
+	const src = "  a /* b */ // c\n\tx\r\n  \"d\"\n // e"
+	want := []struct {
+		tok    token.Token
+		trivia string
+	}{
+		{token.IDENT, "  "},
+		{token.IDENT, " /* b */ // c\n\t"},
+		{token.STRING, "\r\n  "},
+		{token.EOF, "\n // e"},
+	}
+	fset := token.NewFileSet()
+	var s Scanner
+	s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, RetainTrivia)
+	for i, w := range want {
+		_, tok, _ := s.Scan()
+		if tok != w.tok || s.LeadingTrivia() != w.trivia {
+			t.Errorf("token %d: got %s with trivia %q; expected %s with trivia %q", i, tok, s.LeadingTrivia(), w.tok, w.trivia)
+		}
+	}
+
+	// A comment skipped as trivia separates string literals.
+	for _, test := range []struct {
+		src  string
+		want bool
+	}{
+		{`"a"  "b"`, true},
+		{`"a" /**/ "b"`, false},
+	} {
+		s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), nil, RetainTrivia)
+		s.Scan()
+		s.Scan()
+		if s.AdjacentToPrevious() != test.want {
+			t.Errorf("%s: got AdjacentToPrevious() = %v; expected %v", test.src, !test.want, test.want)
+		}
+	}
+}
+
+func TestRetainTriviaRoundTrip(t *testing.T) {
+	srcs := []string{
+		string(source),
+		"",
+		"   ",
+		"\t\ta  \n\n\r\n\tb/**/c//d\n\n",
+		"/* a */\n  // b\n  c /* d\n */ e\n\t\n",
+		"\ufeff a\n",
+	}
+	for _, e := range errors {
+		srcs = append(srcs, e.src)
+	}
+	for _, test := range indentTests {
+		srcs = append(srcs, test.src)
+	}
+	for _, src := range srcs {
+		for _, mode := range []Mode{RetainTrivia, RetainTrivia | ScanIndent | RetainCommentText} {
+			for _, peek := range []bool{false, true} {
+				fset := token.NewFileSet()
+				var s Scanner
+				s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, mode)
+				var buf bytes.Buffer
+				for {
+					if peek {
+						s.PeekToken()
+					}
+					pos, tok, _, end := s.ScanExtended()
+					if tok == token.COMMENT {
+						t.Errorf("%q: got COMMENT token", src)
+					}
+					buf.WriteString(s.LeadingTrivia())
+					buf.WriteString(src[s.file.Offset(pos):s.file.Offset(end)])
+					if tok == token.EOF {
+						break
+					}
+				}
+				if got, want := buf.String(), strings.TrimPrefix(src, "\ufeff"); got != want {
+					t.Errorf("mode %d, peek %v: got %q; expected %q", mode, peek, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestScanNoLit(t *testing.T) {
+	srcs := []string{string(source)}
+	for _, e := range errors {
+		srcs = append(srcs, e.src)
+	}
+	for _, src := range srcs {
+		for _, mode := range []Mode{0, ScanIndent | RetainCommentText} {
+			fset := token.NewFileSet()
+			file := fset.AddFile("", fset.Base(), len(src))
+			var s, t0 Scanner
+			s.InitMode(file, []byte(src), nil, mode)
+			t0.InitMode(file, []byte(src), nil, mode)
+			for {
+				pos, tok := s.ScanNoLit()
+				wantPos, wantTok, _ := t0.Scan()
+				if pos != wantPos || tok != wantTok {
+					t.Errorf("%q: got %s at %s; expected %s at %s", src, tok, fset.Position(pos), wantTok, fset.Position(wantPos))
+					break
+				}
+				if tok == token.EOF {
+					break
+				}
+			}
+			if s.ErrorCount != t0.ErrorCount {
+				t.Errorf("%q: got %d errors; expected %d", src, s.ErrorCount, t0.ErrorCount)
+			}
+		}
+	}
+}
+
+func TestSkipTo(t *testing.T) {
+	const src = "{ f(a & | b) 'ab\n} x }"
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s Scanner
+	handled := 0
+	s.Init(file, []byte(src), func(token.Position, string) { handled++ })
+	s.Scan() // {
+	s.Scan() // f
+	pos, tok, lit := s.SkipTo(token.RBRACE, token.COMMA)
+	if file.Offset(pos) != 17 || tok != token.RBRACE || lit != "" {
+		t.Errorf("got %s %q at offset %d; expected } at 17", tok, lit, file.Offset(pos))
+	}
+	if s.ErrorCount != 3 || handled != 3 {
+		t.Errorf("got %d errors, %d reported; expected 3", s.ErrorCount, handled)
+	}
+	if _, tok, lit := s.Scan(); tok != token.IDENT || lit != "x" {
+		t.Errorf("got %s %q after SkipTo; expected IDENT \"x\"", tok, lit)
+	}
+	if _, tok, _ := s.SkipTo(token.COMMA); tok != token.EOF {
+		t.Errorf("got %s; expected EOF", tok)
+	}
+}
+
+func TestSkipBalanced(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		stop int    // offset SkipBalanced stops at
+		next string // literal of the following token
+		errs int
+	}{
+		// Brackets in strings and comments and of other kinds are not counted.
+		{`f(a { "}" /* ) */ g(h[1}) ')x' ) y`, 31, "y", 0},
+		{"f(a\n(b)\n)y", 8, "y", 0},
+		{"f(a @ \"b)\n) y", 10, "y", 2},
+		{"f(g(a) b", 8, "", 0}, // unbalanced: EOF
+	} {
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(test.src))
+		var s Scanner
+		s.Init(file, []byte(test.src), nil)
+		s.Scan() // f
+		s.Scan() // (
+		if offs := file.Offset(s.SkipBalanced(token.LPAREN, token.RPAREN)); offs != test.stop {
+			t.Errorf("%q: stopped at offset %d; expected %d", test.src, offs, test.stop)
+		}
+		if _, _, lit := s.Scan(); lit != test.next || s.ErrorCount != test.errs {
+			t.Errorf("%q: got %q next with %d errors; expected %q with %d", test.src, lit, s.ErrorCount, test.next, test.errs)
+		}
+	}
+}
+
+func TestSkipToLineEnd(t *testing.T) {
+	const src = "a b \"c\n d /* e\n f */ g\nh ! i\nj\nk"
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s Scanner
+	s.Init(file, []byte(src), nil)
+	next := func() string {
+		pos, _, lit := s.Scan()
+		return fmt.Sprintf("%d %s", file.Offset(pos), lit)
+	}
+	var got []string
+	next() // a
+	s.SkipToLineEnd()
+	got = append(got, next()) // d: the unterminated string ends at the line end
+	s.SkipToLineEnd()
+	got = append(got, next()) // g: the comment is skipped as a whole
+	s.SkipToLineEnd()
+	got = append(got, next()) // h
+	s.PeekToken()             // !, on the current line
+	s.SkipToLineEnd()
+	got = append(got, next()) // j
+	s.PeekToken()             // k, on the next line
+	s.SkipToLineEnd()
+	got = append(got, next()) // k
+	s.SkipToLineEnd()
+	if want := "[8 d 21 g 23 h 29 j 31 k]"; fmt.Sprint(got) != want {
+		t.Errorf("got tokens %v; expected %s", got, want)
+	}
+	if _, tok, _ := s.Scan(); tok != token.EOF || s.ErrorCount != 1 {
+		t.Errorf("got %s with %d errors at the end; expected EOF with 1", tok, s.ErrorCount)
+	}
+}
+
+func TestScanBytes(t *testing.T) {
+	srcs := []string{string(source), ".5e3 x.5 1.. 'a' \"b\" r'c' true null cafe\u0301 \ufeff /*\r*/ \x80\xff //\r"}
+	for _, e := range errors {
+		srcs = append(srcs, e.src)
+	}
+	for _, src := range srcs {
+		for _, mode := range []Mode{0, ScanIndent | RetainCommentText, RetainCommentText | StripCommentCR, NormalizeIdentifiers} {
+			fset := token.NewFileSet()
+			file := fset.AddFile("", fset.Base(), len(src))
+			var s, t0 Scanner
+			s.InitMode(file, []byte(src), nil, mode)
+			t0.InitMode(file, []byte(src), nil, mode)
+			for i := 0; ; i++ {
+				if i%3 == 1 {
+					s.PeekToken() // the literal of a peeked token is returned as well
+				}
+				pos, tok, lit := s.ScanBytes()
+				wantPos, wantTok, wantLit := t0.Scan()
+				if pos != wantPos || tok != wantTok || string(lit) != wantLit {
+					t.Errorf("%q: got %s %q at %s; expected %s %q at %s", src, tok, lit, fset.Position(pos), wantTok, wantLit, fset.Position(wantPos))
+					break
+				}
+				if tok == token.EOF {
+					break
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkScanPool scans small snippets with scanners from a pool.
+func BenchmarkScanPool(b *testing.B) {
+	fset := token.NewFileSet()
+	snippets := make([][]byte, len(tokens))
+	files := make([]*token.File, len(tokens))
+	for i, e := range tokens {
+		snippets[i] = []byte(e.lit)
+		files[i] = fset.AddFile("", fset.Base(), len(e.lit))
+	}
+	pool := sync.Pool{New: func() interface{} { return new(Scanner) }}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			s := pool.Get().(*Scanner)
+			s.Reset(files[i%len(files)], snippets[i%len(snippets)])
+			for {
+				if _, tok := s.ScanNoLit(); tok == token.EOF {
+					break
+				}
+			}
+			pool.Put(s)
+		}
+	})
+}
+
+func BenchmarkScanBytes(b *testing.B) {
+	b.StopTimer()
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(source))
+	var s Scanner
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		s.Init(file, source, nil)
+		for {
+			_, tok, _ := s.ScanBytes()
+			if tok == token.EOF {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	b.StopTimer()
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(source))
+	var s Scanner
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		s.Init(file, source, nil)
+		for {
+			_, tok, _ := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkScanNoLit(b *testing.B) {
+	b.StopTimer()
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(source))
+	var s Scanner
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		s.Init(file, source, nil)
+		for {
+			_, tok := s.ScanNoLit()
+			if tok == token.EOF {
+				break
+			}
+		}
+	}
+}
+
+func TestScanASCIIFastPath(t *testing.T) {
+	// Identifiers and numbers switching between ASCII and other characters
+	// scan as by a reading scanner, which decodes each character.
+	srcs := []string{
+		"abc", "abcä1 b", "äbc1", "ab\x00cd", "ab\xffcd", "a\u0301b", "a_9\nb\r\nc",
+		"123", "12٣4", "0x1fä", "0x1f\x00", "017 0o17 079", "1.25e10i", ".5", "1_000",
+		"x1\u0430y2", "\ufeffab", "a\ufeffb", "9\ufeff", "𝒳a𝒳",
+	}
+	for _, src := range srcs {
+		for _, mode := range []Mode{0, NormalizeIdentifiers, ASCIIIdentsOnly, OctalPrefix, LenientBOM} {
+			fset := token.NewFileSet()
+			want := scanTrace(fset, func(s *Scanner, err ErrorHandler) {
+				s.InitReader(fset.AddFile("", fset.Base(), 0), iotest.OneByteReader(strings.NewReader(src)), err, mode)
+			})
+			fset = token.NewFileSet()
+			got := scanTrace(fset, func(s *Scanner, err ErrorHandler) {
+				s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), err, mode)
+			})
+			if got != want {
+				t.Errorf("%q, mode %d: got\n%s\nexpected\n%s", src, mode, got, want)
+			}
+		}
+	}
+}
+
+// asciiSource is a large source of ASCII text only.
+var asciiSource = []byte(strings.Repeat("func fooBar(x, y int) int {\n\treturn x*1234 + y/0x7f - count_items_2 // sum\n}\n", 1000))
+
+func BenchmarkScanASCII(b *testing.B) {
+	b.StopTimer()
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(asciiSource))
+	var s Scanner
+	b.SetBytes(int64(len(asciiSource)))
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		s.Init(file, asciiSource, nil)
+		for {
+			_, tok := s.ScanNoLit()
+			if tok == token.EOF {
+				break
+			}
+		}
+	}
+}
+
+func TestASCIIIdentsOnly(t *testing.T) {
+	// The identifiers of the token table scan as usual; those that are
+	// not ASCII are reported at their first non-ASCII character.
+	for _, e := range tokens {
+		if e.tok != token.IDENT {
+			continue
+		}
+		want := ""
+		if i := strings.IndexFunc(e.lit, func(r rune) bool { return r >= utf8.RuneSelf }); i >= 0 {
+			r, _ := utf8.DecodeRuneInString(e.lit[i:])
+			want = fmt.Sprintf("%d: non-ASCII character %U in identifier", i, r)
+		}
+		checkASCIIIdent(t, e.lit, e.lit, want)
+	}
+	checkASCIIIdent(t, "p\u0430th", "p\u0430th", "1: non-ASCII character U+0430 in identifier")
+	checkASCIIIdent(t, "\u0430\u0431", "\u0430\u0431", "0: non-ASCII character U+0430 in identifier")
+	checkASCIIIdent(t, "foo_Bar9", "foo_Bar9", "")
+}
+
+func checkASCIIIdent(t *testing.T, src, lit, err string) {
+	fset := token.NewFileSet()
+	var errs []string
+	var s Scanner
+	s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), func(pos token.Position, msg string) {
+		errs = append(errs, fmt.Sprintf("%d: %s", pos.Offset, msg))
+	}, ASCIIIdentsOnly)
+	_, tok, got := s.Scan()
+	if tok != token.IDENT || got != lit {
+		t.Errorf("%q: got %s %q; expected IDENT %q", src, tok, got, lit)
+	}
+	if _, tok, _ := s.Scan(); tok != token.EOF {
+		t.Errorf("%q: got %s after the identifier; expected EOF", src, tok)
+	}
+	want := []string{}
+	if err != "" {
+		want = append(want, err)
+	}
+	if fmt.Sprint(errs) != fmt.Sprint(want) {
+		t.Errorf("%q: got errors %q; expected %q", src, errs, want)
+	}
+}
+
+func TestNormalizeIdentifiers(t *testing.T) {
+	const composed, decomposed = "caf\u00e9", "cafe\u0301"
+	const src = composed + " " + decomposed + " \u0301x"
+	for _, mode := range []Mode{NormalizeIdentifiers, NormalizeIdentifiers | InternIdentifiers} {
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(src))
+		var s Scanner
+		s.InitMode(file, []byte(src), nil, mode)
+		for _, want := range []struct {
+			offs, end int
+			tok       token.Token
+			lit       string
+		}{
+			{0, 5, token.IDENT, composed},
+			{6, 12, token.IDENT, composed}, // original byte range
+			{13, 15, token.ILLEGAL, "\u0301"},
+			{15, 16, token.IDENT, "x"},
+		} {
+			pos, tok, lit, end := s.ScanExtended()
+			if file.Offset(pos) != want.offs || file.Offset(end) != want.end || tok != want.tok || lit != want.lit {
+				t.Errorf("mode %d: got %s %q at [%d, %d); expected %s %q at [%d, %d)", mode, tok, lit, file.Offset(pos), file.Offset(end), want.tok, want.lit, want.offs, want.end)
+			}
+		}
+	}
+
+	// By default, combining marks do not belong to identifiers.
+	toks, lits, errs := ScanAll([]byte(decomposed))
+	if fmt.Sprint(toks) != "[IDENT ILLEGAL]" || lits[0] != "cafe" || errs != 1 {
+		t.Errorf("got %v %q with %d errors; expected IDENT \"cafe\" and ILLEGAL", toks, lits, errs)
+	}
+}
+
+func TestWarnNonNFC(t *testing.T) {
+	const composed, decomposed = "caf\u00e9", "cafe\u0301"
+	const src = composed + " " + decomposed + " \u212b x"
+	for _, test := range []struct {
+		mode Mode
+		lits string
+	}{
+		{WarnNonNFC, "[caf\u00e9 cafe\u0301 \u212b x]"}, // as written
+		{WarnNonNFC | NormalizeIdentifiers, "[caf\u00e9 caf\u00e9 \u00c5 x]"},
+	} {
+		fset := token.NewFileSet()
+		var warns []string
+		var s Scanner
+		s.Warn = func(pos token.Position, msg string) {
+			warns = append(warns, fmt.Sprintf("%d: %s", pos.Offset, msg))
+		}
+		s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, test.mode)
+		var lits []string
+		for {
+			_, tok, lit := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			if tok != token.IDENT {
+				t.Errorf("mode %d: got %s %q; expected identifiers only", test.mode, tok, lit)
+			}
+			lits = append(lits, lit)
+		}
+		if got := fmt.Sprint(lits); got != test.lits {
+			t.Errorf("mode %d: got literals %q; expected %q", test.mode, got, test.lits)
+		}
+		// The decomposed spelling and the Angstrom sign U+212B are not NFC.
+		want := "[6: identifier not in Unicode normal form NFC 13: identifier not in Unicode normal form NFC]"
+		if fmt.Sprint(warns) != want || s.ErrorCount != 0 {
+			t.Errorf("mode %d: got warnings %v and %d errors; expected %s", test.mode, warns, s.ErrorCount, want)
+		}
+	}
+
+	// Warnings are reported while scanning without literals as well.
+	fset := token.NewFileSet()
+	var s Scanner
+	s.InitMode(fset.AddFile("", fset.Base(), len(decomposed)), []byte(decomposed), nil, WarnNonNFC)
+	if _, tok := s.ScanNoLit(); tok != token.IDENT || s.WarningCount != 1 {
+		t.Errorf("ScanNoLit: got %s with %d warnings; expected IDENT with 1", tok, s.WarningCount)
+	}
+}
+
+// identSource is a source consisting mostly of repeated identifiers.
+var identSource = []byte(strings.Repeat("result := compute(value, offset) + compute(offset, value) != false\n", 100))
+
+func benchmarkScanIdents(b *testing.B, mode Mode) {
+	b.StopTimer()
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(identSource))
+	var s Scanner
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		s.InitMode(file, identSource, nil, mode)
+		for {
+			_, tok, _ := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkScanIdents(b *testing.B)       { benchmarkScanIdents(b, 0) }
+func BenchmarkScanIdentsIntern(b *testing.B) { benchmarkScanIdents(b, InternIdentifiers) }
+
+func TestInternIdentifiers(t *testing.T) {
+	srcs := [][]byte{source, identSource, []byte("a 世界 a1 true 世界 \"a\" true a1 r r'a' ä\xffb ä")}
+	for _, src := range srcs {
+		want, wantLits, _ := ScanAll(src)
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(src))
+		var s Scanner
+		s.InitMode(file, src, nil, InternIdentifiers)
+		for i := 0; ; i++ {
+			pos, tok, lit := s.Scan()
+			if tok == token.EOF {
+				if i != len(want) {
+					t.Errorf("%.20q: got %d tokens; expected %d", src, i, len(want))
+				}
+				break
+			}
+			if i >= len(want) || tok != want[i] || lit != wantLits[i] {
+				t.Errorf("%.20q: token %d: got %s %q", src, i, tok, lit)
+				break
+			}
+			// Literals of identifiers are the identifiers in the source.
+			if tok == token.IDENT && lit != string(src[file.Offset(pos):file.Offset(pos)+len(lit)]) {
+				t.Errorf("%.20q: literal %q does not match source", src, lit)
+			}
+		}
+	}
+
+	// Once the identifiers are interned, scanning them does not allocate.
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(identSource))
+	var s Scanner
+	scan := func() {
+		s.InitMode(file, identSource, nil, InternIdentifiers)
+		for {
+			if _, tok, _ := s.Scan(); tok == token.EOF {
+				break
+			}
+		}
+	}
+	scan()
+	if n := testing.AllocsPerRun(10, scan); n != 0 {
+		t.Errorf("got %v allocations per scan; expected 0", n)
+	}
+}
+
+var indentTests = []struct {
+	src  string
+	toks []token.Token
+	errs int
+}{
+	{"a\nb\n", []token.Token{token.IDENT, token.IDENT}, 0},
+	{"a\n  b\nc", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.DEDENT, token.IDENT}, 0},
+	{"a\n\tb\n\t\tc", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.INDENT, token.IDENT, token.DEDENT, token.DEDENT}, 0},
+	{"a\n  b\n    c\nd\n", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.INDENT, token.IDENT, token.DEDENT, token.DEDENT, token.IDENT}, 0},
+	{"a\n  b\n\n   \n  c", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.IDENT, token.DEDENT}, 0},
+	{"a\n  b\n// c\n      /* d */\n  e", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.COMMENT, token.COMMENT, token.IDENT, token.DEDENT}, 0},
+	{"  a\nb", []token.Token{token.INDENT, token.IDENT, token.DEDENT, token.IDENT}, 0},
+	{"a { b\n  c }", []token.Token{token.IDENT, token.LBRACE, token.IDENT, token.INDENT, token.IDENT, token.RBRACE, token.DEDENT}, 0},
+	{"a\n\tb\n        c", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.IDENT, token.DEDENT}, 1},
+	{"a\n  \tb\n\t\tc", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.IDENT, token.DEDENT}, 1},
+	{"a\n  \tb\n  c\n\t d", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.DEDENT, token.IDENT, token.INDENT, token.IDENT, token.DEDENT}, 1},
+	{"a\n  b\n    c\n   d", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.INDENT, token.IDENT, token.DEDENT, token.IDENT, token.DEDENT}, 1},
+	{"a\n/* x\n */ b\n", []token.Token{token.IDENT, token.COMMENT, token.IDENT}, 0},
+	{"a\n  b\n/* x\n */ c\n", []token.Token{token.IDENT, token.INDENT, token.IDENT, token.COMMENT, token.IDENT, token.DEDENT}, 0},
+	{"a\n  /* x */ b\nc", []token.Token{token.IDENT, token.COMMENT, token.IDENT, token.IDENT}, 0},
+	{"a\n/* x\n*/\n  b", []token.Token{token.IDENT, token.COMMENT, token.INDENT, token.IDENT, token.DEDENT}, 0},
+}
+
+func TestScanIndent(t *testing.T) {
+	fset := token.NewFileSet()
+	for _, test := range indentTests {
+		var s Scanner
+		s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), nil, ScanIndent)
+		var toks []token.Token
+		for {
+			_, tok, _ := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			toks = append(toks, tok)
+		}
+		if fmt.Sprint(toks) != fmt.Sprint(test.toks) {
+			t.Errorf("%q: got %v, expected %v", test.src, toks, test.toks)
+		}
+		if s.ErrorCount != test.errs {
+			t.Errorf("%q: got %d errors, expected %d", test.src, s.ErrorCount, test.errs)
+		}
+	}
+}
+
+func TestScanIndentPositions(t *testing.T) {
+	const src = "a\n  b\n\n    c\nd"
+	fset := token.NewFileSet()
+	var s Scanner
+	s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, ScanIndent)
+	for _, want := range []struct {
+		tok       token.Token
+		line, col int
+	}{
+		{token.IDENT, 1, 1},
+		{token.INDENT, 2, 3},
+		{token.IDENT, 2, 3},
+		{token.INDENT, 4, 5},
+		{token.IDENT, 4, 5},
+		{token.DEDENT, 5, 1},
+		{token.DEDENT, 5, 1},
+		{token.IDENT, 5, 1},
+		{token.EOF, 5, 2},
+	} {
+		p, tok, _ := s.Scan()
+		pos := fset.Position(p)
+		if tok != want.tok || pos.Line != want.line || pos.Column != want.col {
+			t.Errorf("got %s at %d:%d, expected %s at %d:%d", tok, pos.Line, pos.Column, want.tok, want.line, want.col)
+		}
+	}
+}
+
+func TestWarnMixedIndent(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string // positions of the warnings
+	}{
+		{"\t foo", []string{"1:1"}},
+		{"    foo", nil},
+		{"\t\tfoo", nil},
+		{"a\n \tb\n\t\tc\n\t d", []string{"2:1", "4:1"}},
+		{"a \t b", nil},                          // not leading white space
+		{"a\n \t\n\t \t// c\n", []string{"3:1"}}, // blank lines don't count, comments do
+		{"\t \n", nil},
+		{"\t x /*\n \ty*/", []string{"1:1"}}, // not inside comments
+	}
+	for _, test := range tests {
+		for _, mode := range []Mode{WarnMixedIndent, WarnMixedIndent | RetainTrivia} {
+			fset := token.NewFileSet()
+			var s Scanner
+			var got []string
+			s.Warn = func(pos token.Position, msg string) {
+				if msg != "mixed tabs and spaces in indentation" {
+					t.Errorf("%q: got warning %q", test.src, msg)
+				}
+				got = append(got, pos.String())
+			}
+			s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), nil, mode)
+			for {
+				if _, tok, _ := s.Scan(); tok == token.EOF {
+					break
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) || s.WarningCount != len(test.want) {
+				t.Errorf("%q: got warnings at %v (count %d); expected %v", test.src, got, s.WarningCount, test.want)
+			}
+			if s.ErrorCount != 0 {
+				t.Errorf("%q: got %d errors", test.src, s.ErrorCount)
+			}
+		}
+	}
+
+	// Without the mode bit, there are no warnings.
+	src := "\t foo"
+	fset := token.NewFileSet()
+	var s Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil)
+	s.Scan()
+	if s.WarningCount != 0 {
+		t.Errorf("got %d warnings without WarnMixedIndent", s.WarningCount)
+	}
+}
+
+func TestOctalPrefix(t *testing.T) {
+	const warning = "leading zero in decimal literal; use 0o prefix for octal"
+	tests := []struct {
+		src  string
+		tok  token.Token
+		lit  string
+		err  string
+		warn string
+	}{
+		{"0o17", token.INT, "0o17", "", ""},
+		{"0O17", token.INT, "0O17", "", ""},
+		{"0o18", token.INT, "0o18", "illegal octal number", ""},
+		{"0o", token.INT, "0o", "illegal octal number", ""},
+		{"0123", token.INT, "0123", "", warning},
+		{"078", token.INT, "078", "illegal octal number", ""},
+		{"0", token.INT, "0", "", ""},
+		{"0x1f", token.INT, "0x1f", "", ""},
+		{"0123.5", token.FLOAT, "0123.5", "", ""},
+		{"0123i", token.IMAG, "0123i", "", ""},
+	}
+	for _, test := range tests {
+		fset := token.NewFileSet()
+		var s Scanner
+		var err, warn string
+		s.Warn = func(pos token.Position, msg string) {
+			if pos.Offset != 0 {
+				t.Errorf("%s: got warning at %s", test.src, pos)
+			}
+			warn = msg
+		}
+		s.InitMode(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), func(_ token.Position, msg string) { err = msg }, OctalPrefix)
+		_, tok, lit := s.Scan()
+		if tok != test.tok || lit != test.lit || err != test.err || warn != test.warn {
+			t.Errorf("%s: got %s %q, error %q, warning %q; expected %s %q, error %q, warning %q", test.src, tok, lit, err, warn, test.tok, test.lit, test.err, test.warn)
+		}
+		if _, tok, _ := s.Scan(); tok != token.EOF {
+			t.Errorf("%s: got %s after the literal; expected EOF", test.src, tok)
+		}
+	}
+
+	// Without the mode bit, the prefix is not recognized and 0123 is
+	// accepted silently.
+	for _, src := range []string{"0o17", "0123"} {
+		fset := token.NewFileSet()
+		var s Scanner
+		s.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil)
+		s.Scan()
+		if src == "0o17" && s.ErrorCount != 1 || src == "0123" && s.ErrorCount != 0 || s.WarningCount != 0 {
+			t.Errorf("%s: got %d errors, %d warnings without OctalPrefix", src, s.ErrorCount, s.WarningCount)
 		}
 	}
 }
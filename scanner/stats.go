@@ -0,0 +1,92 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"sort"
+
+	"github.com/vastri/zolang/token"
+)
+
+// Stats describes the tokens of a source text, as collected by ScanStats
+// and CountTokens.
+//
+type Stats struct {
+	TokenCounts  [token.MaxToken + 1]int // number of tokens of each kind, excluding EOF
+	Tokens       int                     // total number of tokens, excluding EOF
+	Lines        int                     // number of lines of the file
+	CommentBytes int                     // number of source bytes of comments
+	MaxIdentLen  int                     // length in bytes of the longest identifier
+	BytesScanned int                     // number of source bytes scanned
+	ErrorCount   int                     // number of errors encountered
+}
+
+// ScanStats scans src, which is the content of file, and returns the
+// number of tokens of each kind it contains. Literal strings are not
+// built, and errors are only counted.
+//
+func ScanStats(file *token.File, src []byte) Stats {
+	return scanStats(file, src, nil)
+}
+
+// CountTokens is like ScanStats but also returns the errors encountered,
+// in the order they were found.
+//
+func CountTokens(file *token.File, src []byte) (Stats, ErrorList) {
+	var list ErrorList
+	st := scanStats(file, src, func(pos token.Position, msg string) { list.Add(pos, msg) })
+	return st, list
+}
+
+func scanStats(file *token.File, src []byte, err ErrorHandler) Stats {
+	var st Stats
+	var s Scanner
+	s.Init(file, src, err)
+	for {
+		pos, tok := s.ScanNoLit()
+		if tok == token.EOF {
+			break
+		}
+		st.TokenCounts[tok]++
+		// The scanner stops right after the token.
+		switch n := s.offset - file.Offset(pos); tok {
+		case token.COMMENT:
+			st.CommentBytes += n
+		case token.IDENT:
+			if n > st.MaxIdentLen {
+				st.MaxIdentLen = n
+			}
+		}
+		st.Tokens++
+	}
+	st.Lines = file.LineCount()
+	st.BytesScanned = s.offset
+	st.ErrorCount = s.ErrorCount
+	return st
+}
+
+// MostCommon returns the n most common token kinds in st, most common
+// first; kinds with the same count are ordered by token value. Kinds
+// that don't occur are omitted, so the result may have fewer than n
+// entries.
+//
+func (st *Stats) MostCommon(n int) []token.Token {
+	var toks []token.Token
+	for tok, count := range st.TokenCounts {
+		if count > 0 {
+			toks = append(toks, token.Token(tok))
+		}
+	}
+	sort.SliceStable(toks, func(i, j int) bool {
+		return st.TokenCounts[toks[i]] > st.TokenCounts[toks[j]]
+	})
+	if n < 0 {
+		n = 0
+	}
+	if n < len(toks) {
+		toks = toks[:n]
+	}
+	return toks
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vastri/zolang/token"
+)
+
+func TestScanStats(t *testing.T) {
+	var want Stats
+	for _, e := range tokens {
+		want.TokenCounts[e.tok]++
+	}
+	want.Tokens = len(tokens)
+	want.Lines = newlineCount(string(source)) // the final newline starts no line
+	want.CommentBytes = len("/* a comment */// a comment /*\r*///\r")
+	want.MaxIdentLen = len("bar９８７６")
+	want.BytesScanned = len(source)
+
+	fset := token.NewFileSet()
+	got := ScanStats(fset.AddFile("", fset.Base(), len(source)), source)
+	if got != want {
+		for tok, n := range got.TokenCounts {
+			if n != want.TokenCounts[tok] {
+				t.Errorf("%s: got %d tokens, expected %d", token.Token(tok), n, want.TokenCounts[tok])
+			}
+		}
+		t.Errorf("got %d bytes and %d errors, expected %d bytes and no errors", got.BytesScanned, got.ErrorCount, want.BytesScanned)
+		t.Errorf("got %d tokens, %d lines, %d comment bytes, and identifiers of up to %d bytes; expected %d, %d, %d, and %d",
+			got.Tokens, got.Lines, got.CommentBytes, got.MaxIdentLen, want.Tokens, want.Lines, want.CommentBytes, want.MaxIdentLen)
+	}
+
+	// CountTokens yields the same statistics.
+	fset = token.NewFileSet()
+	if got, errs := CountTokens(fset.AddFile("", fset.Base(), len(source)), source); got != want || errs != nil {
+		t.Errorf("CountTokens: got %+v with errors %v; expected %+v", got, errs, want)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	const src = "abc // x\n& abcdef /* y */\n\"c"
+	fset := token.NewFileSet()
+	st, errs := CountTokens(fset.AddFile("", fset.Base(), len(src)), []byte(src))
+	if st.Tokens != 6 || st.TokenCounts[token.IDENT] != 2 || st.TokenCounts[token.COMMENT] != 2 || st.TokenCounts[token.ILLEGAL] != 1 {
+		t.Errorf("got %d tokens: %d IDENT, %d COMMENT, %d ILLEGAL; expected 6: 2, 2, 1",
+			st.Tokens, st.TokenCounts[token.IDENT], st.TokenCounts[token.COMMENT], st.TokenCounts[token.ILLEGAL])
+	}
+	if st.Lines != 3 || st.CommentBytes != len("// x/* y */") || st.MaxIdentLen != len("abcdef") {
+		t.Errorf("got %d lines, %d comment bytes, identifiers of up to %d bytes; expected 3, 11, 6", st.Lines, st.CommentBytes, st.MaxIdentLen)
+	}
+	if st.ErrorCount != 2 || errs.Len() != 2 || errs[0].Pos.Line != 2 || errs[1].Msg != "string literal not terminated" {
+		t.Errorf("got %d errors %v; expected one on line 2 and an unterminated string", st.ErrorCount, errs)
+	}
+}
+
+func TestScanStatsErrors(t *testing.T) {
+	const src = `a & b "c`
+	fset := token.NewFileSet()
+	st := ScanStats(fset.AddFile("", fset.Base(), len(src)), []byte(src))
+	if st.ErrorCount != 2 || st.TokenCounts[token.ILLEGAL] != 1 || st.TokenCounts[token.STRING] != 1 {
+		t.Errorf("got %d errors, %d ILLEGAL and %d STRING tokens; expected 2, 1, and 1",
+			st.ErrorCount, st.TokenCounts[token.ILLEGAL], st.TokenCounts[token.STRING])
+	}
+}
+
+func TestMostCommon(t *testing.T) {
+	var st Stats
+	st.TokenCounts[token.IDENT] = 5
+	st.TokenCounts[token.COMMA] = 3
+	st.TokenCounts[token.INT] = 3
+	st.TokenCounts[token.EOF] = 0
+	st.TokenCounts[token.LPAREN] = 1
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "[]"},
+		{1, "[IDENT]"},
+		{3, "[IDENT INT ,]"},
+		{10, "[IDENT INT , (]"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprint(st.MostCommon(test.n)); got != test.want {
+			t.Errorf("MostCommon(%d) = %s, expected %s", test.n, got, test.want)
+		}
+	}
+}
@@ -6,8 +6,11 @@ package token
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"sort"
 	"sync"
+	"unicode/utf8"
 )
 
 // Position describes an arbitrary source position
@@ -79,6 +82,21 @@ func (p Pos) IsValid() bool {
 	return p != NoPos
 }
 
+// Add returns the position n bytes after p, or before p if n is
+// negative. The result is not checked for validity; p.Add(n) is in the
+// same file as p only if the offset of p plus n is within the file.
+//
+func (p Pos) Add(n int) Pos {
+	return Pos(int(p) + n)
+}
+
+// Sub returns the number of bytes from q to p, p - q; for positions in
+// the same file, it is the difference of their offsets.
+//
+func (p Pos) Sub(q Pos) int {
+	return int(p) - int(q)
+}
+
 // A File is a handle for a file belonging to a FileSet.
 // A File has a name, size, and line offset table.
 //
@@ -89,7 +107,10 @@ type File struct {
 	size int    // file size as provided to AddFile
 
 	// lines and infos are protected by set.mutex
-	lines []int // lines contains the offset of the first character for each line (the first entry is always 0)
+	lines []int      // lines contains the offset of the first character for each line (the first entry is always 0)
+	infos []lineInfo // alternative position information, sorted by offset
+
+	src []byte // file content as provided to AddFileWithContent; or nil
 }
 
 // Name returns the file name of file f as registered with AddFile.
@@ -107,6 +128,35 @@ func (f *File) Size() int {
 	return f.size
 }
 
+// Source returns the content of file f as registered with
+// AddFileWithContent, and true; if f has no content registered,
+// Source returns nil and false. The content must not be modified.
+//
+func (f *File) Source() ([]byte, bool) {
+	return f.src, f.src != nil
+}
+
+// SetSize grows the size of file f to size, for instance while the
+// file content is read incrementally. The file must be the most recently
+// added file of its file set, and size must not be smaller than the
+// current size; otherwise SetSize panics. The base of the file set is
+// adjusted so that subsequently added files follow f.
+//
+func (f *File) SetSize(size int) {
+	s := f.set
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if size < f.size || len(s.files) == 0 || s.files[len(s.files)-1] != f {
+		panic("illegal file size")
+	}
+	base := f.base + size + 1 // +1 because EOF also has a position
+	if base < 0 {
+		panic("token.Pos offset overflow (> 2G of source code in file set)")
+	}
+	f.size = size
+	s.base = base
+}
+
 // LineCount returns the number of lines in file f.
 func (f *File) LineCount() int {
 	f.set.mutex.RLock()
@@ -195,19 +245,87 @@ func (f *File) SetLinesForContent(content []byte) {
 	f.set.mutex.Unlock()
 }
 
+// A lineInfo object describes alternative file, line, and column
+// number information (such as provided via a //line comment in a .zo
+// file) for a given file offset.
+//
+type lineInfo struct {
+	// fields are exported to make them accessible to gob
+	Offset       int
+	Filename     string
+	Line, Column int
+}
+
+// AddLineInfo adds alternative file and line number information for
+// a given file offset. The offset must be larger than the offset for
+// the previously added alternative line info and smaller than the
+// file size; otherwise the information is ignored.
+//
+// AddLineInfo is typically used to register alternative position
+// information for //line filename:line comments in source files.
+//
+func (f *File) AddLineInfo(offset int, filename string, line int) {
+	f.AddLineColumnInfo(offset, filename, line, 0)
+}
+
+// AddLineColumnInfo is like AddLineInfo but also adds alternative
+// column information: the character at offset is reported at the given
+// column, and so are the characters following it on the same line,
+// relative to that column. Columns of subsequent lines are not affected.
+// A column of 0 or less leaves all columns unchanged, as AddLineInfo.
+//
+// AddLineColumnInfo is typically used to register alternative position
+// information for //line filename:line:column comments in source files.
+//
+func (f *File) AddLineColumnInfo(offset int, filename string, line, column int) {
+	if column < 0 {
+		column = 0
+	}
+	f.set.mutex.Lock()
+	if i := len(f.infos); (i == 0 || f.infos[i-1].Offset < offset) && offset < f.size {
+		f.infos = append(f.infos, lineInfo{offset, filename, line, column})
+	}
+	f.set.mutex.Unlock()
+}
+
+// A LineInfo describes alternative position information registered
+// with AddLineInfo or AddLineColumnInfo: from the file offset Offset
+// on, positions are reported relative to line Line of file Filename,
+// and to column Column if it is not 0.
+//
+type LineInfo struct {
+	Offset       int
+	Filename     string
+	Line, Column int
+}
+
+// LineInfos returns a copy of the alternative line information of f,
+// in the order of increasing offsets as registered with AddLineInfo
+// and AddLineColumnInfo.
+//
+func (f *File) LineInfos() []LineInfo {
+	f.set.mutex.RLock()
+	infos := make([]LineInfo, len(f.infos))
+	for i, x := range f.infos {
+		infos[i] = LineInfo(x)
+	}
+	f.set.mutex.RUnlock()
+	return infos
+}
+
 // Pos returns the Pos value for the given file offset;
-// the offset must be <= f.size().
+// the offset must be >= 0 and <= f.Size(); otherwise Pos panics.
 // f.Pos(f.Offset(p)) == p.
 //
 func (f *File) Pos(offset int) Pos {
-	if offset > f.size {
+	if offset < 0 || offset > f.size {
 		panic("illegal file offset")
 	}
 	return Pos(f.base + offset)
 }
 
 // Offset returns the offset for the given file position p;
-// p must be a valid Pos value in that file.
+// p must be a valid Pos value in that file; otherwise Offset panics.
 // f.Offset(f.Pos(offset)) == offset.
 //
 func (f *File) Offset(p Pos) int {
@@ -224,24 +342,160 @@ func (f *File) Line(p Pos) int {
 	return f.Position(p).Line
 }
 
+func searchLineInfos(a []lineInfo, x int) int {
+	return sort.Search(len(a), func(i int) bool { return a[i].Offset > x }) - 1
+}
+
 func searchInts(a []int, x int) int {
 	return sort.Search(len(a), func(i int) bool { return a[i] > x }) - 1
 }
 
+// unpack returns the filename and line and column number for a file offset.
+// If adjusted is set, unpack will return the filename, line, and column
+// information possibly adjusted by //line comments; otherwise those comments
+// are ignored.
+//
+func (f *File) unpack(offset int, adjusted bool) (filename string, line, column int) {
+	filename = f.name
+	if i := searchInts(f.lines, offset); i >= 0 {
+		line, column = i+1, offset-f.lines[i]+1
+	}
+	if adjusted && len(f.infos) > 0 {
+		// almost no files have extra line infos
+		if i := searchLineInfos(f.infos, offset); i >= 0 {
+			alt := &f.infos[i]
+			filename = alt.Filename
+			if i := searchInts(f.lines, alt.Offset); i >= 0 {
+				if alt.Column > 0 && line == i+1 {
+					// same line as the info
+					column = alt.Column + offset - alt.Offset
+				}
+				line += alt.Line - i - 1
+			}
+		}
+	}
+	return
+}
+
+func (f *File) position(p Pos, adjusted bool) (pos Position) {
+	offset := int(p) - f.base
+	pos.Offset = offset
+	pos.Filename, pos.Line, pos.Column = f.unpack(offset, adjusted)
+	return
+}
+
+// PositionFor returns the Position value for the given file position p.
+// If adjusted is set, the position may be adjusted by position-altering
+// //line comments; otherwise those comments are ignored.
+// p must be a Pos value in f or NoPos.
+//
+func (f *File) PositionFor(p Pos, adjusted bool) (pos Position) {
+	if p != NoPos {
+		if int(p) < f.base || int(p) > f.base+f.size {
+			panic("illegal Pos value")
+		}
+		pos = f.position(p, adjusted)
+	}
+	return
+}
+
 // Position returns the Position value for the given file position p.
+// Calling f.Position(p) is equivalent to calling f.PositionFor(p, true).
+//
 func (f *File) Position(p Pos) (pos Position) {
+	return f.PositionFor(p, true)
+}
+
+// Slice returns the part of src, the content of file f, from position
+// start up to but excluding position end. It returns an error if the
+// size of src does not match the file size, if start or end is not a
+// position in f, or if start follows end.
+//
+func (f *File) Slice(start, end Pos, src []byte) ([]byte, error) {
+	if len(src) != f.size {
+		return nil, fmt.Errorf("file size (%d) does not match src len (%d)", f.size, len(src))
+	}
+	for _, p := range []Pos{start, end} {
+		if int(p) < f.base || int(p) > f.base+f.size {
+			return nil, fmt.Errorf("position %d not in file %s", p, f.name)
+		}
+	}
+	if start > end {
+		return nil, fmt.Errorf("start position %d follows end position %d", start, end)
+	}
+	return src[f.Offset(start):f.Offset(end)], nil
+}
+
+// LineColumn returns the line and column number for the given file
+// position p, as f.Position(p) does but without building a Position.
+// The line number may be adjusted by //line comments.
+// p must be a Pos value in f or NoPos; for NoPos, the result is 0, 0.
+//
+func (f *File) LineColumn(p Pos) (line, column int) {
 	if p != NoPos {
 		if int(p) < f.base || int(p) > f.base+f.size {
 			panic("illegal Pos value")
 		}
-		offset := int(p) - f.base
-		pos.Offset = offset
-		pos.Filename = f.name
-		if i := searchInts(f.lines, offset); i >= 0 {
-			pos.Line = i + 1
-			pos.Column = offset - f.lines[i] + 1
+		_, line, column = f.unpack(int(p)-f.base, true)
+	}
+	return
+}
+
+// VisualColumn returns the column number of the given file position p
+// as displayed by an editor with tab stops every tabWidth columns: each
+// tab in the line before p advances to the next tab stop, and any other
+// character counts as one column. Unlike the column of Position, which
+// counts bytes, the result is not affected by multi-byte characters. A
+// tabWidth <= 0 counts tabs as one column. src must be the content of f
+// and p a Pos value in f or NoPos; otherwise VisualColumn panics. For
+// NoPos, the result is 0.
+//
+func (f *File) VisualColumn(p Pos, src []byte, tabWidth int) int {
+	if p == NoPos {
+		return 0
+	}
+	if len(src) != f.size {
+		panic("file size does not match src len")
+	}
+	offset := f.Offset(p)
+	i := 0 // start of the line; the file may have no line table
+	f.set.mutex.RLock()
+	if l := searchInts(f.lines, offset); l >= 0 {
+		i = f.lines[l]
+	}
+	f.set.mutex.RUnlock()
+	col := 1
+	for i < offset {
+		ch, w := utf8.DecodeRune(src[i:offset])
+		if ch == '\t' && tabWidth > 0 {
+			col += tabWidth - (col-1)%tabWidth
+		} else {
+			col++
 		}
+		i += w
 	}
+	return col
+}
+
+// RunePosition is like Position but the column of the result counts
+// characters instead of bytes: it is the number of characters in the
+// line before p, plus one. src must be the content of f and p a Pos
+// value in f or NoPos; otherwise RunePosition panics. The column refers
+// to the source line; it is not adjusted by //line comments.
+//
+func (f *File) RunePosition(p Pos, src []byte) (pos Position) {
+	pos = f.Position(p)
+	pos.Column = f.VisualColumn(p, src, 0)
+	return
+}
+
+// DisplayPosition is like Position but the column of the result is the
+// column displayed with tab stops every tabWidth columns, as returned by
+// VisualColumn; the same restrictions apply as for RunePosition.
+//
+func (f *File) DisplayPosition(p Pos, src []byte, tabWidth int) (pos Position) {
+	pos = f.Position(p)
+	pos.Column = f.VisualColumn(p, src, tabWidth)
 	return
 }
 
@@ -273,6 +527,14 @@ func (s *FileSet) Base() int {
 	return b
 }
 
+// FileCount returns the number of files in the file set.
+func (s *FileSet) FileCount() int {
+	s.mutex.RLock()
+	n := len(s.files)
+	s.mutex.RUnlock()
+	return n
+}
+
 // AddFile adds a new file with a given filename, base offset, and file size
 // to the file set s and returns the file. Multiple files may have the same
 // name. The base offset must not be smaller than the FileSet's Base(), and
@@ -290,6 +552,26 @@ func (s *FileSet) Base() int {
 // values from a file offset.
 //
 func (s *FileSet) AddFile(filename string, base, size int) *File {
+	return s.addFile(filename, base, size, nil)
+}
+
+// AddFileWithContent is like AddFile but also registers src as the
+// content of the file, for instance for tools that need the source
+// text of a file set that has been serialized and read back; see
+// File.Source. The size must be the length of src, and src must not
+// be modified afterwards.
+//
+func (s *FileSet) AddFileWithContent(filename string, base, size int, src []byte) *File {
+	if len(src) != size {
+		panic("illegal base or size")
+	}
+	if src == nil {
+		src = []byte{}
+	}
+	return s.addFile(filename, base, size, src)
+}
+
+func (s *FileSet) addFile(filename string, base, size int, src []byte) *File {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	if base < 0 {
@@ -299,7 +581,7 @@ func (s *FileSet) AddFile(filename string, base, size int) *File {
 		panic("illegal base or size")
 	}
 	// base >= s.base && size >= 0
-	f := &File{s, filename, base, size, []int{0}}
+	f := &File{s, filename, base, size, []int{0}, nil, src}
 	base += size + 1 // +1 because EOF also has a position
 	if base < 0 {
 		panic("token.Pos offset overflow (> 2G of source code in file set)")
@@ -311,6 +593,18 @@ func (s *FileSet) AddFile(filename string, base, size int) *File {
 	return f
 }
 
+// AddFileReader reads r to exhaustion and adds a new file with the given
+// filename at the current Base of the file set, with the size of the
+// content read. It returns the file and the content, which are suitable
+// for scanner.Scanner.Init, and the error that ended reading, if other
+// than io.EOF. If reading fails, the file and content cover the part
+// read up to the error.
+//
+func (s *FileSet) AddFileReader(filename string, r io.Reader) (*File, []byte, error) {
+	src, err := ioutil.ReadAll(r)
+	return s.AddFile(filename, -1, len(src)), src, err
+}
+
 // Iterate calls f for the files in the file set in the order they were added
 // until f returns false.
 //
@@ -328,6 +622,17 @@ func (s *FileSet) Iterate(f func(*File) bool) {
 	}
 }
 
+// Files returns the files in the file set in the order they were added.
+// The result is a snapshot: files added to s later are not included.
+//
+func (s *FileSet) Files() []*File {
+	s.mutex.RLock()
+	files := make([]*File, len(s.files))
+	copy(files, s.files)
+	s.mutex.RUnlock()
+	return files
+}
+
 func searchFiles(a []*File, x int) int {
 	return sort.Search(len(a), func(i int) bool { return a[i].base > x }) - 1
 }
@@ -366,12 +671,23 @@ func (s *FileSet) File(p Pos) (f *File) {
 	return
 }
 
-// Position converts a Pos p in the fileset into a Position value.
-func (s *FileSet) Position(p Pos) (pos Position) {
+// PositionFor converts a Pos p in the fileset into a Position value.
+// If adjusted is set, the position may be adjusted by position-altering
+// //line comments; otherwise those comments are ignored.
+// p must be a Pos value in s or NoPos.
+//
+func (s *FileSet) PositionFor(p Pos, adjusted bool) (pos Position) {
 	if p != NoPos {
 		if f := s.file(p); f != nil {
-			pos = f.Position(p)
+			pos = f.position(p, adjusted)
 		}
 	}
 	return
 }
+
+// Position converts a Pos p in the fileset into a Position value.
+// Calling s.Position(p) is equivalent to calling s.PositionFor(p, true).
+//
+func (s *FileSet) Position(p Pos) (pos Position) {
+	return s.PositionFor(p, true)
+}
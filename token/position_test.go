@@ -7,8 +7,12 @@ package token
 import (
 	"fmt"
 	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
 )
 
 func checkPos(t *testing.T, msg string, got, want Position) {
@@ -34,6 +38,42 @@ func TestNoPos(t *testing.T) {
 	checkPos(t, "nil NoPos", fset.Position(NoPos), Position{})
 	fset = NewFileSet()
 	checkPos(t, "fset NoPos", fset.Position(NoPos), Position{})
+
+	// The first position of a file is valid, even for an empty file
+	// at the lowest base.
+	for _, f := range []*File{fset.AddFile("empty", fset.Base(), 0), fset.AddFile("f", fset.Base(), 10)} {
+		if p := f.Pos(0); p == NoPos || !p.IsValid() {
+			t.Errorf("%s: got f.Pos(0) = %d; expected a valid position", f.Name(), p)
+		}
+	}
+}
+
+func TestPosArithmetic(t *testing.T) {
+	var zero Pos
+	if zero.IsValid() {
+		t.Errorf("zero Pos should not be valid")
+	}
+	fset := NewFileSet()
+	fset.AddFile("a", fset.Base(), 10)
+	f := fset.AddFile("b", fset.Base(), 20)
+	for _, offs := range []int{0, 1, 7, 20} {
+		p := f.Pos(offs)
+		for _, n := range []int{-offs, -1, 0, 1, 20 - offs} {
+			q := p.Add(n)
+			if q.Sub(p) != n || p.Sub(q) != -n || q.Add(-n) != p {
+				t.Errorf("offset %d, n = %d: got %d with Sub %d, %d", offs, n, q, q.Sub(p), p.Sub(q))
+			}
+			if offs+n >= 0 && offs+n <= f.Size() && f.Offset(q) != offs+n {
+				t.Errorf("offset %d, n = %d: got offset %d; want %d", offs, n, f.Offset(q), offs+n)
+			}
+		}
+		if p.Sub(f.Pos(0)) != offs {
+			t.Errorf("offset %d: got %d from the file start", offs, p.Sub(f.Pos(0)))
+		}
+	}
+	if NoPos.Add(1).Add(-1).IsValid() {
+		t.Errorf("NoPos.Add(1).Add(-1) should not be valid")
+	}
 }
 
 var tests = []struct {
@@ -145,6 +185,196 @@ func TestPositions(t *testing.T) {
 	}
 }
 
+func TestLineInfo(t *testing.T) {
+	fset := NewFileSet()
+	f := fset.AddFile("foo", fset.Base(), 500)
+	for offs := 0; offs < f.Size(); offs += 40 {
+		f.AddLine(offs)
+	}
+	// Add infos individually and verify correct position resolution.
+	for _, info := range []struct {
+		offs     int
+		filename string
+		line     int
+	}{
+		{42, "bar", 42},
+		{120, "", 1},
+		{119, "ignored", 7}, // offsets must increase
+		{500, "ignored", 7}, // offsets must be smaller than the file size
+	} {
+		f.AddLineInfo(info.offs, info.filename, info.line)
+	}
+	for _, test := range []struct {
+		offs     int
+		filename string
+		line     int
+		col      int
+	}{
+		{0, "foo", 1, 1},
+		{41, "foo", 2, 2},
+		{42, "bar", 42, 3},   // line 2 starts at offset 40
+		{80, "bar", 43, 1},   // next line
+		{119, "bar", 43, 40}, // still within the range of the first info
+		{120, "", 1, 1},
+		{499, "", 10, 20},
+	} {
+		p := f.Pos(test.offs)
+		checkPos(t, fmt.Sprintf("offs = %d", test.offs), f.Position(p), Position{test.filename, test.offs, test.line, test.col})
+		checkPos(t, fmt.Sprintf("fset offs = %d", test.offs), fset.Position(p), Position{test.filename, test.offs, test.line, test.col})
+	}
+	// Unadjusted positions ignore the line infos.
+	p := f.Pos(80)
+	checkPos(t, "unadjusted", f.PositionFor(p, false), Position{"foo", 80, 3, 1})
+	checkPos(t, "fset unadjusted", fset.PositionFor(p, false), Position{"foo", 80, 3, 1})
+}
+
+func TestLineColumnInfo(t *testing.T) {
+	fset := NewFileSet()
+	f := fset.AddFile("foo", fset.Base(), 500)
+	for offs := 0; offs < f.Size(); offs += 40 {
+		f.AddLine(offs)
+	}
+	f.AddLineColumnInfo(45, "bar", 10, 20) // line 2 starts at offset 40
+	f.AddLineColumnInfo(130, "baz", 30, 0) // line 4 starts at offset 120
+	f.AddLineColumnInfo(200, "", 50, -1)   // a negative column is ignored
+	for _, test := range []struct {
+		offs     int
+		filename string
+		line     int
+		col      int
+	}{
+		{44, "foo", 2, 5},
+		{45, "bar", 10, 20},
+		{79, "bar", 10, 54},
+		{80, "bar", 11, 1}, // next line
+		{130, "baz", 30, 11},
+		{160, "baz", 31, 1},
+		{200, "", 50, 1},
+	} {
+		p := f.Pos(test.offs)
+		checkPos(t, fmt.Sprintf("offs = %d", test.offs), f.Position(p), Position{test.filename, test.offs, test.line, test.col})
+		checkPos(t, fmt.Sprintf("fset offs = %d", test.offs), fset.Position(p), Position{test.filename, test.offs, test.line, test.col})
+	}
+	// Unadjusted positions ignore the line infos.
+	p := f.Pos(45)
+	checkPos(t, "unadjusted", f.PositionFor(p, false), Position{"foo", 45, 2, 6})
+	if got := fmt.Sprint(f.LineInfos()); got != "[{45 bar 10 20} {130 baz 30 0} {200  50 0}]" {
+		t.Errorf("got line infos %s", got)
+	}
+}
+
+func checkPanic(t *testing.T, msg, want string, f func()) {
+	defer func() {
+		if got := recover(); got != want {
+			t.Errorf("%s: got panic %v; want %q", msg, got, want)
+		}
+	}()
+	f()
+}
+
+func TestFileBounds(t *testing.T) {
+	fset := NewFileSet()
+	fset.AddFile("a", fset.Base(), 10)
+	f := fset.AddFile("b", fset.Base(), 10)
+	for _, offs := range []int{-100, -1, 11, 100} {
+		checkPanic(t, fmt.Sprintf("Pos(%d)", offs), "illegal file offset", func() { f.Pos(offs) })
+	}
+	for _, p := range []Pos{NoPos, Pos(f.Base() - 1), Pos(f.Base() + f.Size() + 1), Pos(1 << 20)} {
+		checkPanic(t, fmt.Sprintf("Offset(%d)", p), "illegal Pos value", func() { f.Offset(p) })
+	}
+	for _, p := range []Pos{Pos(f.Base() - 1), Pos(f.Base() + f.Size() + 1)} {
+		checkPanic(t, fmt.Sprintf("Position(%d)", p), "illegal Pos value", func() { f.Position(p) })
+	}
+	// The bounds themselves are valid.
+	if f.Offset(f.Pos(0)) != 0 || f.Offset(f.Pos(f.Size())) != f.Size() {
+		t.Errorf("offsets 0 and %d do not round-trip", f.Size())
+	}
+}
+
+func TestSetSize(t *testing.T) {
+	fset := NewFileSet()
+	a := fset.AddFile("a", fset.Base(), 10)
+	f := fset.AddFile("b", fset.Base(), 0)
+	checkPanic(t, "SetSize of earlier file", "illegal file size", func() { a.SetSize(20) })
+	for _, size := range []int{0, 1, 5, 100} {
+		f.SetSize(size)
+		if f.Size() != size {
+			t.Errorf("got size %d; want %d", f.Size(), size)
+		}
+		if b := fset.Base(); b != f.Base()+size+1 {
+			t.Errorf("size %d: got base %d; want %d", size, b, f.Base()+size+1)
+		}
+	}
+	checkPanic(t, "shrinking SetSize", "illegal file size", func() { f.SetSize(99) })
+	if p := f.Pos(100); fset.File(p) != f {
+		t.Errorf("position at the new end is not in file %s", f.Name())
+	}
+	if g := fset.AddFile("c", -1, 1); g.Base() != f.Base()+101 {
+		t.Errorf("got next base %d; want %d", g.Base(), f.Base()+101)
+	}
+}
+
+func TestAddFileReader(t *testing.T) {
+	fset := NewFileSet()
+	fset.AddFile("a", -1, 10)
+	const src = "x := 1\ny := 2\n"
+	f, b, err := fset.AddFileReader("b", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != "b" || f.Size() != len(src) || string(b) != src {
+		t.Errorf("got file %s of size %d and content %q; want file b of size %d and content %q", f.Name(), f.Size(), b, len(src), src)
+	}
+	if f.Base() != 12 || fset.Base() != f.Base()+len(src)+1 {
+		t.Errorf("got base %d and file set base %d; want 12 and %d", f.Base(), fset.Base(), 12+len(src)+1)
+	}
+
+	// A read error ends the content.
+	f, b, err = fset.AddFileReader("c", iotest.TimeoutReader(strings.NewReader(src)))
+	if err != iotest.ErrTimeout || f.Size() != len(src) || string(b) != src {
+		t.Errorf("got size %d, content %q, error %v; want size %d, content %q, error %v", f.Size(), b, err, len(src), src, iotest.ErrTimeout)
+	}
+}
+
+// positionRx matches the string form of a valid position.
+var positionRx = regexp.MustCompile(`^(?:(.*):)?(\d+):(\d+)$`)
+
+func TestPositionString(t *testing.T) {
+	tests := []struct {
+		pos  Position
+		want string
+	}{
+		{Position{"foo.zo", 10, 3, 7}, "foo.zo:3:7"},
+		{Position{"dir/foo.zo", 0, 1, 1}, "dir/foo.zo:1:1"},
+		{Position{"c:\\foo.zo", 0, 12, 1}, "c:\\foo.zo:12:1"},
+		{Position{"", 10, 3, 7}, "3:7"},
+		{Position{"foo.zo", 10, 0, 7}, "foo.zo"},
+		{Position{"", 10, 0, 0}, "-"},
+		{Position{}, "-"},
+	}
+	for _, test := range tests {
+		got := test.pos.String()
+		if got != test.want {
+			t.Errorf("%#v: got %q; want %q", test.pos, got, test.want)
+			continue
+		}
+		if !test.pos.IsValid() {
+			continue
+		}
+		// Valid positions can be parsed back.
+		m := positionRx.FindStringSubmatch(got)
+		if m == nil {
+			t.Errorf("%q does not match %s", got, positionRx)
+			continue
+		}
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		if m[1] != test.pos.Filename || line != test.pos.Line || col != test.pos.Column {
+			t.Errorf("%q: parsed %q, %d, %d; want %q, %d, %d", got, m[1], line, col, test.pos.Filename, test.pos.Line, test.pos.Column)
+		}
+	}
+}
+
 func TestFiles(t *testing.T) {
 	fset := NewFileSet()
 	for i, test := range tests {
@@ -169,6 +399,50 @@ func TestFiles(t *testing.T) {
 	}
 }
 
+func TestFileSetFiles(t *testing.T) {
+	fset := NewFileSet()
+	var want []*File
+	for _, name := range []string{"a", "b", "c"} {
+		want = append(want, fset.AddFile(name, -1, 10))
+	}
+	files := fset.Files()
+	fset.AddFile("d", -1, 10)
+	if len(files) != len(want) {
+		t.Fatalf("got %d files; want %d", len(files), len(want))
+	}
+	for i, f := range files {
+		if f != want[i] {
+			t.Errorf("file %d: got %s; want %s", i, f.Name(), want[i].Name())
+		}
+	}
+	if n := len(fset.Files()); n != 4 {
+		t.Errorf("got %d files after adding another; want 4", n)
+	}
+	if files := NewFileSet().Files(); len(files) != 0 {
+		t.Errorf("got %d files in an empty file set", len(files))
+	}
+}
+
+func TestFileSetBase(t *testing.T) {
+	fset := NewFileSet()
+	if b := fset.Base(); b != 1 {
+		t.Errorf("got initial base %d; want 1", b)
+	}
+	if n := fset.FileCount(); n != 0 {
+		t.Errorf("got initial file count %d; want 0", n)
+	}
+	for i, test := range tests {
+		base := fset.Base()
+		fset.AddFile(test.filename, base, test.size)
+		if b := fset.Base(); b != base+test.size+1 {
+			t.Errorf("%s: got base %d; want %d", test.filename, b, base+test.size+1)
+		}
+		if n := fset.FileCount(); n != i+1 {
+			t.Errorf("%s: got file count %d; want %d", test.filename, n, i+1)
+		}
+	}
+}
+
 // FileSet.File should return nil if Pos is past the end of the FileSet.
 func TestFileSetPastEnd(t *testing.T) {
 	fset := NewFileSet()
@@ -215,3 +489,162 @@ func TestFileSetRace(t *testing.T) {
 	}
 	stop.Wait()
 }
+
+func TestLineColumn(t *testing.T) {
+	fset := NewFileSet()
+	f := fset.AddFile("foo", fset.Base(), 1000)
+	for offs := 0; offs < f.Size(); offs += 7 {
+		f.AddLine(offs)
+	}
+	f.AddLineInfo(500, "bar", 100)
+	for offs := 0; offs <= f.Size(); offs++ {
+		p := f.Pos(offs)
+		line, col := f.LineColumn(p)
+		if want := f.Position(p); line != want.Line || col != want.Column {
+			t.Errorf("offset %d: got %d:%d; want %d:%d", offs, line, col, want.Line, want.Column)
+		}
+	}
+	if line, col := f.LineColumn(NoPos); line != 0 || col != 0 {
+		t.Errorf("NoPos: got %d:%d; want 0:0", line, col)
+	}
+}
+
+func benchmarkFile() *File {
+	fset := NewFileSet()
+	f := fset.AddFile("benchmark.zo", fset.Base(), 1<<16)
+	for offs := 0; offs < f.Size(); offs += 40 {
+		f.AddLine(offs)
+	}
+	return f
+}
+
+func BenchmarkPosition(b *testing.B) {
+	f := benchmarkFile()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pos := f.Position(f.Pos(i % f.Size()))
+		_ = pos.Line + pos.Column
+	}
+}
+
+func BenchmarkLineColumn(b *testing.B) {
+	f := benchmarkFile()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		line, col := f.LineColumn(f.Pos(i % f.Size()))
+		_ = line + col
+	}
+}
+
+func TestSlice(t *testing.T) {
+	const src = "x := 1\nfoo(a, b)\n\"c\"\n"
+	fset := NewFileSet()
+	fset.AddFile("before", -1, 10)
+	f := fset.AddFile("f", -1, len(src))
+	fset.AddFile("after", -1, 10)
+	f.SetLinesForContent([]byte(src))
+	p := f.Pos
+	tests := []struct {
+		start, end Pos
+		want       string
+	}{
+		{p(0), p(1), "x"},              // token
+		{p(2), p(4), ":="},             // token
+		{p(7), p(10), "foo"},           // token
+		{p(0), p(6), "x := 1"},         // line
+		{p(17), p(20), `"c"`},          // line
+		{p(5), p(11), "1\nfoo("},       // multi-line span
+		{p(0), p(len(src)), src},       // everything
+		{p(3), p(3), ""},               // empty
+		{p(len(src)), p(len(src)), ""}, // empty at EOF
+	}
+	for _, test := range tests {
+		got, err := f.Slice(test.start, test.end, []byte(src))
+		if err != nil || string(got) != test.want {
+			t.Errorf("Slice(%d, %d) = %q, %v; want %q", f.Offset(test.start), f.Offset(test.end), got, err, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		start, end Pos
+		src        string
+	}{
+		{p(4), p(3), src},                         // start > end
+		{p(0), Pos(f.Base() + len(src) + 1), src}, // end in next file
+		{Pos(f.Base() - 1), p(3), src},            // start in previous file
+		{NoPos, p(3), src},
+		{p(0), p(3), src[1:]}, // wrong size
+	} {
+		if got, err := f.Slice(test.start, test.end, []byte(test.src)); got != nil || err == nil {
+			t.Errorf("Slice(%d, %d) = %q, %v; want error", test.start, test.end, got, err)
+		}
+	}
+}
+
+func TestVisualColumn(t *testing.T) {
+	const src = "a\tb\n  \tc\n\t  \td\nä\te"
+	fset := NewFileSet()
+	f := fset.AddFile("", fset.Base(), len(src))
+	f.SetLinesForContent([]byte(src))
+	for _, test := range []struct {
+		offs, width, col int
+	}{
+		{0, 4, 1},
+		{2, 4, 5}, // b
+		{2, 8, 9},
+		{2, 0, 3},
+		{7, 4, 5}, // c: the tab after two spaces ends at the same stop
+		{7, 1, 4},
+		{13, 4, 9},  // d
+		{13, 8, 17}, // d
+		{18, 4, 5},  // e: ä counts as one column
+	} {
+		if got := f.VisualColumn(f.Pos(test.offs), []byte(src), test.width); got != test.col {
+			t.Errorf("offset %d, tab width %d: got column %d; want %d", test.offs, test.width, got, test.col)
+		}
+	}
+	if got := f.VisualColumn(NoPos, []byte(src), 4); got != 0 {
+		t.Errorf("NoPos: got column %d; want 0", got)
+	}
+
+	// Without a line table, columns count from the start of the file.
+	f.SetLines([]int{})
+	if got := f.VisualColumn(f.Pos(7), []byte(src), 4); got != 13 {
+		t.Errorf("no lines: got column %d; want 13", got)
+	}
+}
+
+func TestRunePosition(t *testing.T) {
+	const src = "a := \"日本語\"\n\t世界 = x\n"
+	fset := NewFileSet()
+	f := fset.AddFile("x.zo", fset.Base(), len(src))
+	f.SetLinesForContent([]byte(src))
+	for _, test := range []struct {
+		offs                   int
+		line, byteCol, runeCol int
+		displayCol             int // with tab width 4
+	}{
+		{0, 1, 1, 1, 1},
+		{5, 1, 6, 6, 6},     // "
+		{15, 1, 16, 10, 10}, // closing "
+		{17, 2, 1, 1, 1},    // tab
+		{18, 2, 2, 2, 5},    // 世
+		{21, 2, 5, 3, 6},    // 界
+		{25, 2, 9, 5, 8},    // =
+		{27, 2, 11, 7, 10},  // x
+	} {
+		p := f.Pos(test.offs)
+		if pos := f.Position(p); pos.Line != test.line || pos.Column != test.byteCol {
+			t.Errorf("offset %d: got Position %s; want %d:%d", test.offs, pos, test.line, test.byteCol)
+		}
+		want := Position{"x.zo", test.offs, test.line, test.runeCol}
+		checkPos(t, fmt.Sprintf("RunePosition offset %d", test.offs), f.RunePosition(p, []byte(src)), want)
+		want.Column = test.displayCol
+		checkPos(t, fmt.Sprintf("DisplayPosition offset %d", test.offs), f.DisplayPosition(p, []byte(src), 4), want)
+	}
+	if pos := f.RunePosition(NoPos, []byte(src)); pos.IsValid() {
+		t.Errorf("NoPos: got valid position %s", pos)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// WriteProto and ReadProto use the protocol buffer wire format for the
+// messages
+//
+//      message FileSet {
+//              int64 base = 1;
+//              repeated File files = 2;
+//      }
+//
+//      message File {
+//              string name = 1;
+//              int64 base = 2;
+//              int64 size = 3;
+//              repeated int64 lines = 4; // packed
+//              repeated LineInfo infos = 5;
+//              bytes src = 6; // present if the content is registered
+//      }
+//
+//      message LineInfo {
+//              int64 offset = 1;
+//              string filename = 2;
+//              int64 line = 3;
+//              int64 column = 4;
+//      }
+
+// Wire types of the protocol buffer encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProto = errors.New("malformed protocol buffer encoding of file set")
+
+// WriteProto writes the file set s to w in protocol buffer encoding.
+func (s *FileSet) WriteProto(w io.Writer) error {
+	ss := s.serialize()
+	var b, msg []byte
+	b = appendInt(b, 1, ss.Base)
+	for _, f := range ss.Files {
+		msg = appendFile(msg[:0], &f)
+		b = appendBytes(b, 2, msg)
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func appendFile(b []byte, f *serializedFile) []byte {
+	b = appendBytes(b, 1, []byte(f.Name))
+	b = appendInt(b, 2, f.Base)
+	b = appendInt(b, 3, f.Size)
+	if len(f.Lines) > 0 {
+		var lines []byte
+		for _, offs := range f.Lines {
+			lines = appendVarint(lines, uint64(offs))
+		}
+		b = appendBytes(b, 4, lines)
+	}
+	var info []byte
+	for _, x := range f.Infos {
+		info = appendInt(info[:0], 1, x.Offset)
+		info = appendBytes(info, 2, []byte(x.Filename))
+		info = appendInt(info, 3, x.Line)
+		if x.Column != 0 {
+			info = appendInt(info, 4, x.Column)
+		}
+		b = appendBytes(b, 5, info)
+	}
+	if f.HasSrc {
+		b = appendBytes(b, 6, f.Src)
+	}
+	return b
+}
+
+func appendVarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+func appendInt(b []byte, field int, x int) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireVarint)
+	return appendVarint(b, uint64(x))
+}
+
+func appendBytes(b []byte, field int, x []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(x)))
+	return append(b, x...)
+}
+
+// ReadProto reads a file set in protocol buffer encoding, as written
+// by WriteProto, from r into s; s must not be nil. Unknown fields are
+// skipped. If the encoding is malformed, s is left unchanged.
+//
+func (s *FileSet) ReadProto(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var ss serializedFileSet
+	err = readFields(b, func(field, wire int, x uint64, data []byte) error {
+		switch {
+		case field == 1 && wire == wireVarint:
+			ss.Base = int(x)
+		case field == 2 && wire == wireBytes:
+			var f serializedFile
+			if err := readFile(data, &f); err != nil {
+				return err
+			}
+			ss.Files = append(ss.Files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.deserialize(&ss)
+	return nil
+}
+
+func readFile(b []byte, f *serializedFile) error {
+	return readFields(b, func(field, wire int, x uint64, data []byte) error {
+		switch {
+		case field == 1 && wire == wireBytes:
+			f.Name = string(data)
+		case field == 2 && wire == wireVarint:
+			f.Base = int(x)
+		case field == 3 && wire == wireVarint:
+			f.Size = int(x)
+		case field == 4 && wire == wireVarint:
+			// unpacked encoding of a single line
+			f.Lines = append(f.Lines, int(x))
+		case field == 4 && wire == wireBytes:
+			for len(data) > 0 {
+				x, n := binary.Uvarint(data)
+				if n <= 0 {
+					return errProto
+				}
+				f.Lines = append(f.Lines, int(x))
+				data = data[n:]
+			}
+		case field == 5 && wire == wireBytes:
+			var info lineInfo
+			err := readFields(data, func(field, wire int, x uint64, data []byte) error {
+				switch {
+				case field == 1 && wire == wireVarint:
+					info.Offset = int(x)
+				case field == 2 && wire == wireBytes:
+					info.Filename = string(data)
+				case field == 3 && wire == wireVarint:
+					info.Line = int(x)
+				case field == 4 && wire == wireVarint:
+					info.Column = int(x)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			f.Infos = append(f.Infos, info)
+		case field == 6 && wire == wireBytes:
+			f.Src = append([]byte{}, data...)
+			f.HasSrc = true
+		}
+		return nil
+	})
+}
+
+// readFields calls fn for each field of the message encoded in b with
+// the field number, the wire type, and the value of the field: x for
+// a varint, data for a length-delimited field.
+//
+func readFields(b []byte, fn func(field, wire int, x uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 || key>>3 == 0 {
+			return errProto
+		}
+		b = b[n:]
+		field, wire := int(key>>3), int(key&7)
+		var x uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			if x, n = binary.Uvarint(b); n <= 0 {
+				return errProto
+			}
+		case wireFixed64:
+			n = 8
+		case wireBytes:
+			if x, n = binary.Uvarint(b); n <= 0 || x > uint64(len(b)-n) {
+				return errProto
+			}
+			data = b[n : n+int(x)]
+			n += int(x)
+		case wireFixed32:
+			n = 4
+		default:
+			return errProto
+		}
+		if n > len(b) {
+			return errProto
+		}
+		b = b[n:]
+		if err := fn(field, wire, x, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
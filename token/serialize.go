@@ -4,12 +4,18 @@
 
 package token
 
+import "bytes"
+
 type serializedFile struct {
-	// Fields correspond 1:1 to fields with same (lower-case) name in File.
-	Name  string
-	Base  int
-	Size  int
-	Lines []int
+	// Fields correspond 1:1 to fields with same (lower-case) name in File;
+	// HasSrc records whether src is set, since gob omits empty slices.
+	Name   string
+	Base   int
+	Size   int
+	Lines  []int
+	Infos  []lineInfo
+	Src    []byte
+	HasSrc bool
 }
 
 type serializedFileSet struct {
@@ -23,33 +29,82 @@ func (s *FileSet) Read(decode func(interface{}) error) error {
 	if err := decode(&ss); err != nil {
 		return err
 	}
+	s.deserialize(&ss)
+	return nil
+}
 
+// deserialize replaces the content of s with ss.
+func (s *FileSet) deserialize(ss *serializedFileSet) {
 	s.mutex.Lock()
 	s.base = ss.Base
 	files := make([]*File, len(ss.Files))
 	for i := 0; i < len(ss.Files); i++ {
 		f := &ss.Files[i]
-		files[i] = &File{s, f.Name, f.Base, f.Size, f.Lines}
+		src := f.Src
+		if f.HasSrc && src == nil {
+			src = []byte{}
+		}
+		files[i] = &File{s, f.Name, f.Base, f.Size, f.Lines, f.Infos, src}
 	}
 	s.files = files
 	s.last = nil
 	s.mutex.Unlock()
-
-	return nil
 }
 
 // Write calls encode to serialize the file set s.
 func (s *FileSet) Write(encode func(interface{}) error) error {
+	return encode(s.serialize())
+}
+
+// serialize returns a snapshot of s.
+func (s *FileSet) serialize() serializedFileSet {
 	var ss serializedFileSet
 
 	s.mutex.Lock()
 	ss.Base = s.base
 	files := make([]serializedFile, len(s.files))
 	for i, f := range s.files {
-		files[i] = serializedFile{f.name, f.base, f.size, f.lines}
+		files[i] = serializedFile{f.name, f.base, f.size, f.lines, f.infos, f.src, f.src != nil}
 	}
 	ss.Files = files
 	s.mutex.Unlock()
 
-	return encode(ss)
+	return ss
+}
+
+// Equal reports whether the file sets s and other have the same base
+// and files with the same names, bases, sizes, lines, alternative
+// position information, and contents; it is meant for testing
+// serialization round trips. Equal takes a snapshot of each file set
+// in turn, holding only one lock at a time, so that it cannot deadlock
+// with a concurrent Equal call comparing the file sets the other way
+// around.
+//
+func (s *FileSet) Equal(other *FileSet) bool {
+	if s == other {
+		return true
+	}
+	p, q := s.serialize(), other.serialize()
+	if p.Base != q.Base || len(p.Files) != len(q.Files) {
+		return false
+	}
+	for i := range p.Files {
+		f, g := &p.Files[i], &q.Files[i]
+		if f.Name != g.Name || f.Base != g.Base || f.Size != g.Size ||
+			len(f.Lines) != len(g.Lines) || len(f.Infos) != len(g.Infos) ||
+			f.HasSrc != g.HasSrc || !bytes.Equal(f.Src, g.Src) {
+			return false
+		}
+		for j := range f.Lines {
+			if f.Lines[j] != g.Lines[j] {
+				return false
+			}
+		}
+		for j := range f.Infos {
+			if f.Infos[j] != g.Infos[j] {
+				return false
+			}
+		}
+	}
+	return true
 }
@@ -7,6 +7,7 @@ package token
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"testing"
 )
@@ -56,6 +57,18 @@ func equal(p, q *FileSet) error {
 				return fmt.Errorf("different offsets for %q", f.name)
 			}
 		}
+		if len(f.infos) != len(g.infos) {
+			return fmt.Errorf("different number of line infos for %q: %d != %d", f.name, len(f.infos), len(g.infos))
+		}
+		for j, l := range f.infos {
+			m := g.infos[j]
+			if l != m {
+				return fmt.Errorf("different line infos for %q", f.name)
+			}
+		}
+		if (f.src == nil) != (g.src == nil) || !bytes.Equal(f.src, g.src) {
+			return fmt.Errorf("different content for %q", f.name)
+		}
 	}
 
 	// We don't care about .last - it's just a cache
@@ -81,6 +94,9 @@ func checkSerialize(t *testing.T, p *FileSet) {
 	if err := equal(p, q); err != nil {
 		t.Errorf("filesets not identical: %s", err)
 	}
+	if !p.Equal(q) || !q.Equal(p) {
+		t.Errorf("filesets not Equal")
+	}
 }
 
 func TestSerialization(t *testing.T) {
@@ -95,5 +111,145 @@ func TestSerialization(t *testing.T) {
 			f.AddLine(offs)
 		}
 		checkSerialize(t, p)
+		// Add some line infos.
+		for offs := 0; offs < f.Size(); offs += 70 + i {
+			f.AddLineInfo(offs, fmt.Sprintf("alt%d", offs), offs/10+1)
+		}
+		checkSerialize(t, p)
+	}
+}
+
+func TestSerializeContent(t *testing.T) {
+	const src = "a := 1\nb := 2\n"
+	p := NewFileSet()
+	f := p.AddFileWithContent("a.zo", -1, len(src), []byte(src))
+	f.SetLinesForContent([]byte(src))
+	p.AddFile("b.zo", -1, 10)
+	p.AddFileWithContent("empty.zo", -1, 0, nil)
+	checkSerialize(t, p)
+
+	check := func(how string, q *FileSet) {
+		var got []string
+		q.Iterate(func(f *File) bool {
+			b, ok := f.Source()
+			got = append(got, fmt.Sprintf("%s %v %q", f.Name(), ok, b))
+			return true
+		})
+		if want := `[a.zo true "a := 1\nb := 2\n" b.zo false "" empty.zo true ""]`; fmt.Sprint(got) != want {
+			t.Errorf("%s: got %s; want %s", how, got, want)
+		}
+	}
+	check("original", p)
+
+	for _, c := range []struct {
+		name  string
+		write func(*bytes.Buffer) error
+		read  func(*FileSet, *bytes.Buffer) error
+	}{
+		{"json", func(buf *bytes.Buffer) error { return p.Write(json.NewEncoder(buf).Encode) },
+			func(q *FileSet, buf *bytes.Buffer) error { return q.Read(json.NewDecoder(buf).Decode) }},
+		{"proto", func(buf *bytes.Buffer) error { return p.WriteProto(buf) },
+			func(q *FileSet, buf *bytes.Buffer) error { return q.ReadProto(buf) }},
+	} {
+		var buf bytes.Buffer
+		if err := c.write(&buf); err != nil {
+			t.Fatalf("%s: writing fileset failed: %s", c.name, err)
+		}
+		q := NewFileSet()
+		if err := c.read(q, &buf); err != nil {
+			t.Fatalf("%s: reading fileset failed: %s", c.name, err)
+		}
+		check(c.name, q)
+		if err := equal(p, q); err != nil {
+			t.Errorf("%s: filesets not identical: %s", c.name, err)
+		}
+	}
+
+	checkPanic(t, "size mismatch", "illegal base or size", func() { p.AddFileWithContent("c.zo", -1, 1, nil) })
+}
+
+func TestEqual(t *testing.T) {
+	build := func() *FileSet {
+		s := NewFileSet()
+		f := s.AddFile("a", s.Base(), 10)
+		f.AddLine(4)
+		f.AddLineInfo(4, "alt", 100)
+		s.AddFile("b", s.Base()+1, 5)
+		return s
+	}
+	p := build()
+	if !p.Equal(p) || !p.Equal(build()) {
+		t.Errorf("identical filesets not Equal")
+	}
+
+	for i, change := range []func(*FileSet){
+		func(s *FileSet) { s.AddFile("c", s.Base(), 0) },
+		func(s *FileSet) { s.File(Pos(1)).AddLine(8) },
+		func(s *FileSet) { s.File(Pos(1)).AddLineInfo(8, "alt", 200) },
+		func(s *FileSet) { s.File(Pos(1)).name = "c" },
+		func(s *FileSet) { s.File(Pos(1)).lines[1] = 5 },
+		func(s *FileSet) { s.File(Pos(1)).infos[0].Line = 101 },
+		func(s *FileSet) { s.File(Pos(1)).infos[0].Column = 3 },
+		func(s *FileSet) { s.File(Pos(1)).SetLinesForContent(nil) },
+		func(s *FileSet) { s.File(Pos(1)).src = []byte("0123456789") },
+	} {
+		q := build()
+		change(q)
+		if p.Equal(q) || q.Equal(p) {
+			t.Errorf("change %d: different filesets Equal", i)
+		}
+	}
+}
+
+func TestProto(t *testing.T) {
+	p := NewFileSet()
+	for i := 0; i < 10; i++ {
+		f := p.AddFile(fmt.Sprintf("file%d", i), p.Base()+i, i*100)
+		for offs := 0; offs < f.Size(); offs += 40 + i {
+			f.AddLine(offs)
+		}
+		for offs := 0; offs < f.Size(); offs += 70 + i {
+			f.AddLineColumnInfo(offs, fmt.Sprintf("alt%d", offs), offs/10+1, i)
+		}
+	}
+	p.AddFile("empty file", -1, 0) // 22 bytes encoded
+
+	var buf bytes.Buffer
+	if err := p.WriteProto(&buf); err != nil {
+		t.Fatalf("writing fileset failed: %s", err)
+	}
+	b := buf.Bytes()
+	q := NewFileSet()
+	if err := q.ReadProto(bytes.NewReader(b)); err != nil {
+		t.Fatalf("reading fileset failed: %s", err)
+	}
+	if err := equal(p, q); err != nil || !p.Equal(q) {
+		t.Errorf("filesets not identical: %v", err)
+	}
+
+	var js bytes.Buffer
+	if err := p.Write(json.NewEncoder(&js).Encode); err != nil {
+		t.Fatalf("writing fileset as JSON failed: %s", err)
+	}
+	if len(b) >= js.Len() {
+		t.Errorf("got %d bytes; JSON encoding has %d", len(b), js.Len())
+	}
+
+	// Encodings truncated within the last file are malformed and leave
+	// the file set unchanged.
+	for n := 1; n < 20; n++ {
+		if err := q.ReadProto(bytes.NewReader(b[:len(b)-n])); err == nil {
+			t.Errorf("reading %d of %d bytes: got no error", len(b)-n, len(b))
+		}
+	}
+	if !p.Equal(q) {
+		t.Errorf("fileset changed by reading a malformed encoding")
+	}
+
+	// Unknown fields are skipped.
+	b = append([]byte{0x18, 0x01, 0x21, 0, 0, 0, 0, 0, 0, 0, 0, 0x2a, 0x01, 'x', 0x35, 0, 0, 0, 0}, b...)
+	q = NewFileSet()
+	if err := q.ReadProto(bytes.NewReader(b)); err != nil || !p.Equal(q) {
+		t.Errorf("unknown fields: got error %v, Equal %v", err, p.Equal(q))
 	}
 }
@@ -6,7 +6,11 @@
 //
 package token
 
-import "strconv"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // Token is the set of lexical tokens of zolang.
 type Token int
@@ -17,37 +21,49 @@ const (
 	ILLEGAL Token = iota
 	EOF
 	COMMENT
+	INDENT // increase of line indentation; see scanner.ScanIndent
+	DEDENT // decrease of line indentation; see scanner.ScanIndent
 
 	literal_beg
 	// Identifiers and basic type literals
 	IDENT     // main
 	BOOL      // true/false
+	NULL      // null; nil, see scanner.NilKeyword
+	BLANK     // _
 	INT       // 12345
 	FLOAT     // 123.45
+	IMAG      // 123.45i
+	CHAR      // 'a'
 	STRING    // "abc"
-	RAWSTRING // 'abc'
+	RAWSTRING // 'abc', r"abc"
 	literal_end
 
 	operator_beg
 	// Operators and delimiters
+	arithmetic_beg
 	ADD // +
 	SUB // -
 	MUL // *
 	QUO // /
 	REM // %
+	arithmetic_end
 
 	AND // &&
 	OR  // ||
+	XOR // ^
 
-	EQL    // ==
-	LSS    // <
-	GTR    // >
-	ASSIGN // =
-	NOT    // !
-
+	comparison_beg
+	EQL // ==
+	LSS // <
+	GTR // >
 	NEQ // !=
 	LEQ // <=
 	GEQ // >=
+	comparison_end
+
+	ASSIGN // =
+	NOT    // !
+	DEFINE // :=
 
 	LPAREN // (
 	LBRACK // [
@@ -59,19 +75,33 @@ const (
 	RBRACK // ]
 	RBRACE // }
 	COLON  // :
+	HASH   // #; see scanner.AllowHash
+
+	LBRACE_HASH // #{; see scanner.AllowSetLiterals
 	operator_end
 )
 
+// MaxToken is the largest token value. It must be updated when
+// tokens are added after it.
+//
+const MaxToken Token = LBRACE_HASH
+
 var tokens = [...]string{
 	ILLEGAL: "ILLEGAL",
 
 	EOF:     "EOF",
 	COMMENT: "COMMENT",
+	INDENT:  "INDENT",
+	DEDENT:  "DEDENT",
 
 	IDENT:     "IDENT",
 	BOOL:      "BOOL",
+	NULL:      "NULL",
+	BLANK:     "BLANK",
 	INT:       "INT",
 	FLOAT:     "FLOAT",
+	IMAG:      "IMAG",
+	CHAR:      "CHAR",
 	STRING:    "STRING",
 	RAWSTRING: "RAWSTRING",
 
@@ -83,17 +113,19 @@ var tokens = [...]string{
 
 	AND: "&&",
 	OR:  "||",
+	XOR: "^",
 
-	EQL:    "==",
-	LSS:    "<",
-	GTR:    ">",
-	ASSIGN: "=",
-	NOT:    "!",
-
+	EQL: "==",
+	LSS: "<",
+	GTR: ">",
 	NEQ: "!=",
 	LEQ: "<=",
 	GEQ: ">=",
 
+	ASSIGN: "=",
+	NOT:    "!",
+	DEFINE: ":=",
+
 	LPAREN: "(",
 	LBRACK: "[",
 	LBRACE: "{",
@@ -104,6 +136,9 @@ var tokens = [...]string{
 	RBRACK: "]",
 	RBRACE: "}",
 	COLON:  ":",
+	HASH:   "#",
+
+	LBRACE_HASH: "#{",
 }
 
 // String returns the string corresponding to the token tok.
@@ -118,6 +153,43 @@ func (tok Token) String() string {
 	return s
 }
 
+// tokenValues maps the strings of the tokens to the tokens.
+var tokenValues = func() map[string]Token {
+	m := make(map[string]Token, len(tokens))
+	for i, s := range tokens {
+		if s != "" {
+			m[s] = Token(i)
+		}
+	}
+	return m
+}()
+
+// MarshalText implements the encoding.TextMarshaler interface;
+// the text of tok is tok.String().
+//
+func (tok Token) MarshalText() ([]byte, error) {
+	return []byte(tok.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+// It accepts the strings returned by String, including those of the
+// form token(N) for values without a name.
+//
+func (tok *Token) UnmarshalText(text []byte) error {
+	s := string(text)
+	if t, ok := tokenValues[s]; ok {
+		*tok = t
+		return nil
+	}
+	if strings.HasPrefix(s, "token(") && strings.HasSuffix(s, ")") {
+		if n, err := strconv.Atoi(s[len("token(") : len(s)-1]); err == nil {
+			*tok = Token(n)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown token %q", s)
+}
+
 // A set of constants for precedence-based expression parsing.
 // Non-operators have lowest precedence, followed by operators
 // starting with precedence 1 up to unary operators. The highest
@@ -142,7 +214,7 @@ func (op Token) Precedence() int {
 		return 2
 	case EQL, NEQ, LSS, LEQ, GTR, GEQ:
 		return 3
-	case ADD, SUB:
+	case ADD, SUB, XOR:
 		return 4
 	case MUL, QUO, REM:
 		return 5
@@ -152,6 +224,11 @@ func (op Token) Precedence() int {
 
 // Predicates
 
+// Valid returns true for tokens in the range [0, MaxToken]; it returns
+// false otherwise.
+//
+func (tok Token) Valid() bool { return tok >= 0 && tok <= MaxToken }
+
 // IsLiteral returns true for tokens corresponding to identifiers
 // and basic type literals; it returns false otherwise.
 //
@@ -161,3 +238,86 @@ func (tok Token) IsLiteral() bool { return literal_beg < tok && tok < literal_en
 // delimiters; it returns false otherwise.
 //
 func (tok Token) IsOperator() bool { return operator_beg < tok && tok < operator_end }
+
+// IsArithmetic returns true for tokens corresponding to arithmetic
+// operators; it returns false otherwise.
+//
+func (tok Token) IsArithmetic() bool { return arithmetic_beg < tok && tok < arithmetic_end }
+
+// IsComparison returns true for tokens corresponding to comparison
+// operators; it returns false otherwise.
+//
+func (tok Token) IsComparison() bool { return comparison_beg < tok && tok < comparison_end }
+
+// IsOpenBracket returns true for tokens opening a bracketed construct:
+// LPAREN, LBRACK, LBRACE, and LBRACE_HASH; it returns false otherwise.
+//
+func (tok Token) IsOpenBracket() bool {
+	switch tok {
+	case LPAREN, LBRACK, LBRACE, LBRACE_HASH:
+		return true
+	}
+	return false
+}
+
+// IsCloseBracket returns true for tokens closing a bracketed construct:
+// RPAREN, RBRACK, and RBRACE; it returns false otherwise.
+//
+func (tok Token) IsCloseBracket() bool {
+	switch tok {
+	case RPAREN, RBRACK, RBRACE:
+		return true
+	}
+	return false
+}
+
+// MatchingBracket returns the token closing the construct opened by tok,
+// or the token opening the construct closed by tok, and true; if tok is
+// not a bracket, it returns ILLEGAL and false. A set literal opened by
+// LBRACE_HASH is closed by RBRACE, whose matching bracket is LBRACE.
+//
+func (tok Token) MatchingBracket() (Token, bool) {
+	switch tok {
+	case LPAREN:
+		return RPAREN, true
+	case RPAREN:
+		return LPAREN, true
+	case LBRACK:
+		return RBRACK, true
+	case RBRACK:
+		return LBRACK, true
+	case LBRACE, LBRACE_HASH:
+		return RBRACE, true
+	case RBRACE:
+		return LBRACE, true
+	}
+	return ILLEGAL, false
+}
+
+// Width returns the number of bytes of the source spelling of tok if
+// all occurrences of tok are spelled the same, as for operators and
+// delimiters and the blank identifier; for instance, LEQ has width 2
+// and ADD and BLANK have width 1. It returns 0 for tokens whose spelling
+// varies, such as IDENT or BOOL.
+//
+func (tok Token) Width() int {
+	switch {
+	case tok.IsOperator():
+		return len(tokens[tok])
+	case tok == BLANK:
+		return len("_")
+	}
+	return 0
+}
+
+// IsUnaryOp returns true for operator tokens that may also be used as
+// prefix operators (+x, -x, !x, ^x); it returns false otherwise. The
+// scanner does not distinguish unary from binary uses of a token.
+//
+func (tok Token) IsUnaryOp() bool {
+	switch tok {
+	case ADD, SUB, NOT, XOR:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Vastri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package token
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// allTokens returns all tokens, excluding the unexported range markers.
+func allTokens() []Token {
+	var toks []Token
+	for tok := Token(0); tok <= MaxToken; tok++ {
+		if !strings.HasPrefix(tok.String(), "token(") {
+			toks = append(toks, tok)
+		}
+	}
+	return toks
+}
+
+func TestOperatorCategories(t *testing.T) {
+	arithmetic := map[Token]bool{ADD: true, SUB: true, MUL: true, QUO: true, REM: true}
+	comparison := map[Token]bool{EQL: true, NEQ: true, LSS: true, LEQ: true, GTR: true, GEQ: true}
+	for _, tok := range allTokens() {
+		if got := tok.IsArithmetic(); got != arithmetic[tok] {
+			t.Errorf("%s: got IsArithmetic() = %v, want %v", tok, got, arithmetic[tok])
+		}
+		if got := tok.IsComparison(); got != comparison[tok] {
+			t.Errorf("%s: got IsComparison() = %v, want %v", tok, got, comparison[tok])
+		}
+		if tok.IsArithmetic() && tok.IsComparison() {
+			t.Errorf("%s is both arithmetic and comparison operator", tok)
+		}
+		if (tok.IsArithmetic() || tok.IsComparison()) && !tok.IsOperator() {
+			t.Errorf("%s is not an operator", tok)
+		}
+	}
+}
+
+func TestMaxToken(t *testing.T) {
+	if MaxToken != operator_end-1 {
+		t.Errorf("MaxToken = %s; want the last token %s", MaxToken, operator_end-1)
+	}
+	if !MaxToken.Valid() {
+		t.Errorf("MaxToken is not valid")
+	}
+	for _, tok := range []Token{-1, MaxToken + 1, operator_end} {
+		if tok.Valid() {
+			t.Errorf("%s is valid", tok)
+		}
+	}
+	for _, tok := range allTokens() {
+		if !tok.Valid() {
+			t.Errorf("%s is not valid", tok)
+		}
+	}
+}
+
+func TestUnaryOps(t *testing.T) {
+	unary := map[Token]bool{ADD: true, SUB: true, NOT: true, XOR: true}
+	for _, tok := range allTokens() {
+		if got := tok.IsUnaryOp(); got != unary[tok] {
+			t.Errorf("%s: got IsUnaryOp() = %v, want %v", tok, got, unary[tok])
+		}
+	}
+}
+
+func TestWidth(t *testing.T) {
+	for _, tok := range allTokens() {
+		want := 0
+		if tok.IsOperator() {
+			want = len(tok.String())
+		} else if tok == BLANK {
+			want = 1
+		}
+		if got := tok.Width(); got != want {
+			t.Errorf("%s: got Width() = %d, want %d", tok, got, want)
+		}
+	}
+	if got := LEQ.Width(); got != 2 {
+		t.Errorf("LEQ: got Width() = %d, want 2", got)
+	}
+	if got := Token(-1).Width(); got != 0 {
+		t.Errorf("token(-1): got Width() = %d, want 0", got)
+	}
+}
+
+func TestBrackets(t *testing.T) {
+	pairs := map[Token]Token{
+		LPAREN:      RPAREN,
+		RPAREN:      LPAREN,
+		LBRACK:      RBRACK,
+		RBRACK:      LBRACK,
+		LBRACE:      RBRACE,
+		RBRACE:      LBRACE,
+		LBRACE_HASH: RBRACE,
+	}
+	open := map[Token]bool{LPAREN: true, LBRACK: true, LBRACE: true, LBRACE_HASH: true}
+	for _, tok := range allTokens() {
+		match, ok := tok.MatchingBracket()
+		want, isBracket := pairs[tok]
+		if match != want || ok != isBracket {
+			t.Errorf("%s: got MatchingBracket() = %s, %v; want %s, %v", tok, match, ok, want, isBracket)
+		}
+		if got := tok.IsOpenBracket(); got != open[tok] {
+			t.Errorf("%s: got IsOpenBracket() = %v, want %v", tok, got, open[tok])
+		}
+		if got := tok.IsCloseBracket(); got != (isBracket && !open[tok]) {
+			t.Errorf("%s: got IsCloseBracket() = %v, want %v", tok, got, isBracket && !open[tok])
+		}
+		if ok && match.IsOpenBracket() == tok.IsOpenBracket() {
+			t.Errorf("%s: matching bracket %s is of the same kind", tok, match)
+		}
+	}
+	if _, ok := IDENT.MatchingBracket(); ok || IDENT.IsOpenBracket() || IDENT.IsCloseBracket() {
+		t.Errorf("IDENT is a bracket")
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	literals := map[Token]bool{IDENT: true, BOOL: true, NULL: true, BLANK: true, INT: true, FLOAT: true, IMAG: true, CHAR: true, STRING: true, RAWSTRING: true}
+	for _, tok := range allTokens() {
+		if got := tok.IsLiteral(); got != literals[tok] {
+			t.Errorf("%s: got IsLiteral() = %v, want %v", tok, got, literals[tok])
+		}
+	}
+}
+
+func TestMarshalText(t *testing.T) {
+	toks := append(allTokens(), ILLEGAL, -1, MaxToken+1, operator_end)
+	for _, tok := range toks {
+		text, err := tok.MarshalText()
+		if err != nil || string(text) != tok.String() {
+			t.Errorf("%s: got %q, %v; want %q", tok, text, err, tok.String())
+		}
+		var got Token
+		if err := got.UnmarshalText(text); err != nil || got != tok {
+			t.Errorf("%s: unmarshaled %s, %v", tok, got, err)
+		}
+	}
+
+	// Tokens embedded in JSON are human-readable.
+	type info struct{ Tok Token }
+	b, err := json.Marshal([]info{{IDENT}, {ADD}, {LBRACE_HASH}})
+	if want := `[{"Tok":"IDENT"},{"Tok":"+"},{"Tok":"#{"}]`; err != nil || string(b) != want {
+		t.Errorf("got JSON %s, %v; want %s", b, err, want)
+	}
+	var infos []info
+	if err := json.Unmarshal(b, &infos); err != nil || len(infos) != 3 || infos[2].Tok != LBRACE_HASH {
+		t.Errorf("got %v, %v after unmarshaling", infos, err)
+	}
+
+	for _, s := range []string{"", "foo", "token(", "token(x)", "+ "} {
+		var tok Token
+		if err := tok.UnmarshalText([]byte(s)); err == nil {
+			t.Errorf("%q: unmarshaled %s without error", s, tok)
+		}
+	}
+}